@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// servingLock guards serving, as the signal handler stops serving from its own goroutine
+	servingLock sync.Mutex
+
+	// serving indicates that runs are started from the api server (see 'bashful serve'), where a fatal error fails the
+	// run (as a runFailure panic) instead of exiting the process
+	serving bool
+
+	// runCancelled indicates that the current run was cancelled from the api server (no further tasks are started)
+	runCancelled bool
+
+	// cancelRequests receives a request from the api server to cancel the current run, handled between task updates
+	// (see listenAndDisplay) as only the run may change the state of its tasks
+	cancelRequests = make(chan bool, 1)
+
+	// failureLock guards failureMessage, as fatal errors are recovered within concurrently running tasks
+	failureLock sync.Mutex
+
+	// failureMessage is the first fatal error recovered within a goroutine started by the current run (see recoverFailure)
+	failureMessage string
+)
+
+// runFailure is raised (as a panic) in place of exiting the process when a fatal error occurs while serving
+type runFailure struct {
+	message string
+}
+
+// apiServer queues and executes runs requested over http (one at a time, as a run uses the global config and screen)
+type apiServer struct {
+	// token is the value every request must provide (see authorized), if set
+	token string
+
+	// lock guards runs and the state of each run, as runs are executed concurrently with the handling of requests
+	lock sync.Mutex
+
+	// runs are all runs requested thus far (in the order requested)
+	runs []*apiRun
+
+	// queue holds the runs waiting to be executed
+	queue chan *apiRun
+
+	// mux routes all api requests
+	mux *http.ServeMux
+}
+
+// apiRunRequest is the body of a request to start a new run (the yaml is given either inline or as a file path)
+type apiRunRequest struct {
+	Path     string   `json:"path,omitempty"`
+	Yaml     string   `json:"yaml,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	OnlyTags []string `json:"only-tags,omitempty"`
+	Args     []string `json:"args,omitempty"`
+}
+
+// apiRun is a single run requested over http
+type apiRun struct {
+	ID        string        `json:"id"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Requested time.Time     `json:"requested-time"`
+	Request   apiRunRequest `json:"request"`
+	Result    *runResult    `json:"result,omitempty"`
+
+	// cancelRequested indicates that the run should be stopped (or never started, if still queued)
+	cancelRequested bool
+
+	// tasks are the tasks of the run (once started)
+	tasks []*Task
+}
+
+// apiTaskLog is the output of a single task of a run
+type apiTaskLog struct {
+	Name   string   `json:"name"`
+	Group  string   `json:"group,omitempty"`
+	Output []string `json:"output"`
+}
+
+// apiQueueLength is the most number of runs that can wait to be executed
+const apiQueueLength = 100
+
+// newAPIServer creates an api server that immediately starts executing runs as they are queued
+func newAPIServer(token string) *apiServer {
+	server := &apiServer{token: token, queue: make(chan *apiRun, apiQueueLength), mux: http.NewServeMux()}
+	server.mux.HandleFunc("/runs", server.serveRuns)
+	server.mux.HandleFunc("/runs/", server.serveRun)
+	enableSnapshots()
+
+	go func() {
+		for item := range server.queue {
+			server.execute(item)
+		}
+	}()
+	return server
+}
+
+// serveAPI hosts the api server on the given address (see localAddress), returning only if the server fails
+func serveAPI(address, token string) {
+	address = localAddress(address)
+	listener, err := net.Listen("tcp", address)
+	checkError(err, "Unable to serve the api on "+address)
+	fmt.Println(bold("Serving the api on http://" + listener.Addr().String()))
+
+	setServing(true)
+	err = http.Serve(listener, newAPIServer(token))
+	setServing(false)
+	checkError(err, "Unable to serve the api")
+}
+
+// setServing indicates whether a fatal error fails the run instead of exiting the process (see exitWithErrorMessage)
+func setServing(value bool) {
+	servingLock.Lock()
+	defer servingLock.Unlock()
+	serving = value
+}
+
+// isServing indicates that a fatal error fails the run instead of exiting the process
+func isServing() bool {
+	servingLock.Lock()
+	defer servingLock.Unlock()
+	return serving
+}
+
+// ServeHTTP checks the token of the given request before serving it
+func (server *apiServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(request, server.token) {
+		writeJSONError(writer, http.StatusUnauthorized, "a valid token is required")
+		return
+	}
+	server.mux.ServeHTTP(writer, request)
+}
+
+// writeJSON writes the given value as the json response body
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
+
+// writeJSONError writes the given message as a json error response
+func writeJSONError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, map[string]string{"error": message})
+}
+
+// serveRuns lists all runs (GET) or queues a new run (POST) given as json (an apiRunRequest) or as a yaml body (with
+// 'tags', 'only-tags', and 'args' query values)
+func (server *apiServer) serveRuns(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		writeJSON(writer, http.StatusOK, server.runs)
+
+	case "POST":
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			writeJSONError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var runRequest apiRunRequest
+		if strings.Contains(request.Header.Get("Content-Type"), "json") {
+			if err := json.Unmarshal(body, &runRequest); err != nil {
+				writeJSONError(writer, http.StatusBadRequest, "Unable to parse the request: "+err.Error())
+				return
+			}
+		} else {
+			query := request.URL.Query()
+			runRequest = apiRunRequest{Yaml: string(body), Tags: splitQueryList(query["tags"]), OnlyTags: splitQueryList(query["only-tags"]), Args: query["args"]}
+		}
+
+		yamlString := []byte(runRequest.Yaml)
+		if runRequest.Path != "" {
+			if runRequest.Yaml != "" {
+				writeJSONError(writer, http.StatusBadRequest, "Only one of 'path' and 'yaml' can be given")
+				return
+			}
+			yamlString, err = ioutil.ReadFile(runRequest.Path)
+			if err != nil {
+				writeJSONError(writer, http.StatusBadRequest, "Unable to read yaml config: "+err.Error())
+				return
+			}
+		}
+		if len(yamlString) == 0 {
+			writeJSONError(writer, http.StatusBadRequest, "A yaml config must be given (as a 'path' or 'yaml' value)")
+			return
+		}
+		if len(runRequest.Tags) > 0 && len(runRequest.OnlyTags) > 0 {
+			writeJSONError(writer, http.StatusBadRequest, "Options 'tags' and 'only-tags' are mutually exclusive.")
+			return
+		}
+
+		server.lock.Lock()
+		item := &apiRun{ID: strconv.Itoa(len(server.runs) + 1), Status: "queued", Requested: time.Now(), Request: runRequest}
+		item.Request.Yaml = string(yamlString)
+		select {
+		case server.queue <- item:
+			server.runs = append(server.runs, item)
+		default:
+			server.lock.Unlock()
+			writeJSONError(writer, http.StatusServiceUnavailable, "Too many runs are queued")
+			return
+		}
+		defer server.lock.Unlock()
+		writeJSON(writer, http.StatusAccepted, item)
+
+	default:
+		writeJSONError(writer, http.StatusMethodNotAllowed, "Only GET and POST are allowed")
+	}
+}
+
+// splitQueryList splits every comma delimited query value into a single list
+func splitQueryList(values []string) (list []string) {
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// serveRun serves the state of a single run ('/runs/<id>'), the task output of a run ('/runs/<id>/logs', optionally
+// only the task named by the 'task' query value), or cancels a run ('/runs/<id>/cancel' or DELETE '/runs/<id>')
+func (server *apiServer) serveRun(writer http.ResponseWriter, request *http.Request) {
+	fields := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, "/runs/"), "/"), "/")
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	var item *apiRun
+	for _, candidate := range server.runs {
+		if candidate.ID == fields[0] {
+			item = candidate
+		}
+	}
+	if item == nil || len(fields) > 2 {
+		writeJSONError(writer, http.StatusNotFound, "No such run")
+		return
+	}
+
+	// the tasks of the running run are only read from the latest snapshot (the run changes them as it goes)
+	var snapshot runSnapshot
+	if item.Status == "running" {
+		snapshot = currentSnapshot()
+	} else {
+		snapshot = runSnapshot{commands: snapshotCommands(item.tasks)}
+	}
+
+	action := ""
+	if len(fields) == 2 {
+		action = fields[1]
+	}
+	switch {
+	case action == "" && request.Method == "GET":
+		if item.Status == "running" {
+			item.Result = &snapshot.result
+		}
+		writeJSON(writer, http.StatusOK, item)
+
+	case action == "logs" && request.Method == "GET":
+		logs := []apiTaskLog{}
+		for _, command := range snapshot.commands {
+			if request.URL.Query().Get("task") != "" && request.URL.Query().Get("task") != command.name {
+				continue
+			}
+			taskLog := apiTaskLog{Name: command.name, Group: command.group, Output: []string{}}
+			for _, line := range command.task.CapturedOutput() {
+				taskLog.Output = append(taskLog.Output, stripEscapes(line))
+			}
+			logs = append(logs, taskLog)
+		}
+		writeJSON(writer, http.StatusOK, logs)
+
+	case (action == "cancel" && request.Method == "POST") || (action == "" && request.Method == "DELETE"):
+		switch item.Status {
+		case "queued":
+			item.cancelRequested = true
+			item.Status = "cancelled"
+		case "running":
+			item.cancelRequested = true
+			select {
+			case cancelRequests <- true:
+			default:
+				// the run is already asked to cancel
+			}
+		}
+		writeJSON(writer, http.StatusOK, item)
+
+	default:
+		writeJSONError(writer, http.StatusMethodNotAllowed, "Unsupported method for this resource")
+	}
+}
+
+// acceptCancelRequest cancels the current run if the api server has asked to (without waiting for a request)
+func acceptCancelRequest() {
+	select {
+	case <-cancelRequests:
+		cancelRun()
+	default:
+	}
+}
+
+// cancelRun stops the current run: no further tasks are started and all running tasks are cancelled
+func cancelRun() {
+	runCancelled = true
+	exitSignaled = true
+	for _, task := range allTasks {
+		for _, candidate := range append([]*Task{task}, task.Children...) {
+			candidate.Cancel()
+		}
+	}
+}
+
+// execute runs the given queued run (unless it was cancelled while queued) and records the outcome
+func (server *apiServer) execute(item *apiRun) {
+	server.lock.Lock()
+	if item.cancelRequested {
+		server.lock.Unlock()
+		return
+	}
+	item.Status = "running"
+	config.Cli = CliOptions{RunTags: append(item.Request.Tags, item.Request.OnlyTags...), ExecuteOnlyMatchedTags: len(item.Request.OnlyTags) > 0, Args: item.Request.Args, PlainUI: true}
+	if item.Request.Path != "" {
+		config.Cli.YamlPath, _ = filepath.Abs(item.Request.Path)
+	}
+	server.lock.Unlock()
+
+	message := runWithoutExit([]byte(item.Request.Yaml))
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	item.tasks = allTasks
+	result := newRunResult(item.tasks, time.Now())
+	item.Result = &result
+	item.Status = result.Status
+	switch {
+	case message != "":
+		item.Status = "error"
+		item.Error = message
+	case item.cancelRequested:
+		item.Status = "cancelled"
+	}
+}
+
+// runWithoutExit runs the given yaml, returning the message of any fatal error instead of exiting the process
+func runWithoutExit(yamlString []byte) (message string) {
+	allTasks = nil
+	publishSnapshot()
+
+	// a cancel request left over from a previous run
+	select {
+	case <-cancelRequests:
+	default:
+	}
+	failureLock.Lock()
+	failureMessage = ""
+	failureLock.Unlock()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			failure, ok := recovered.(runFailure)
+			if !ok {
+				panic(recovered)
+			}
+			// the commands still running are stopped, and their events discarded until they have all completed
+			for _, task := range allTasks {
+				task.Kill()
+				task.discardEvents()
+			}
+			message = failure.message
+		}
+	}()
+
+	run(yamlString, map[string]string{})
+
+	failureLock.Lock()
+	defer failureLock.Unlock()
+	return failureMessage
+}
+
+// recoverFailure handles the given value recovered (see recover) within a goroutine started by the run. While serving,
+// a fatal error raised there would otherwise crash the server: instead the goroutine carries on without what failed
+// (e.g. a task fails, or a log is not written) and the run fails with the first such error once complete (see
+// runWithoutExit). Returns the message of the runFailure recovered (empty if nothing was recovered); anything other than
+// a runFailure is raised again.
+func recoverFailure(recovered interface{}) string {
+	if recovered == nil {
+		return ""
+	}
+	failure, ok := recovered.(runFailure)
+	if !ok {
+		panic(recovered)
+	}
+
+	failureLock.Lock()
+	defer failureLock.Unlock()
+	if failureMessage == "" {
+		failureMessage = failure.message
+	}
+	return failure.message
+}
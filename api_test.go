@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/repr"
+)
+
+// apiRequest sends a request to the given api server, decoding the json response into the given value
+func apiRequest(t *testing.T, server *httptest.Server, method, path, contentType, body string, value interface{}) int {
+	request, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if value != nil {
+		json.NewDecoder(response.Body).Decode(value)
+	}
+	return response.StatusCode
+}
+
+// waitForRun polls the given run until it is no longer queued or running
+func waitForRun(t *testing.T, server *httptest.Server, id string) apiRun {
+	var item apiRun
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(20 * time.Millisecond) {
+		apiRequest(t, server, "GET", "/runs/"+id, "", "", &item)
+		if item.Status != "queued" && item.Status != "running" {
+			return item
+		}
+	}
+	t.Fatal("Run", id, "did not finish")
+	return item
+}
+
+func TestAPIRuns(t *testing.T) {
+	setServing(true)
+	defer setServing(false)
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	scr.SetRenderer(newVirtualTerminal(80, 24))
+
+	server := httptest.NewServer(newAPIServer(""))
+	defer server.Close()
+
+	// a yaml body with tags given as query values
+	yamlStr := `
+config:
+  stop-on-failure: false
+tasks:
+  - name: greet
+    cmd: echo hello $1
+  - name: tagged
+    cmd: "true"
+    tags: other
+  - name: fails
+    cmd: echo broken >&2; exit 4
+`
+	var item apiRun
+	if code := apiRequest(t, server, "POST", "/runs?tags=app&args=world", "application/x-yaml", yamlStr, &item); code != http.StatusAccepted || item.ID != "1" {
+		t.Fatal("TestAPIRuns: Expected the run to be queued, got", code, repr.String(item))
+	}
+	item = waitForRun(t, server, "1")
+	if item.Status != "failed" || item.Result == nil {
+		t.Fatal("TestAPIRuns: Expected a failed run with a result, got", repr.String(item))
+	}
+	var statuses []string
+	for _, task := range item.Result.Tasks {
+		statuses = append(statuses, task.Name+":"+task.Status+":"+task.Command)
+	}
+	expected := []string{"greet:success:echo hello world", "fails:failed:echo broken >&2; exit 4", "tagged:skipped:true"}
+	if repr.String(expected) != repr.String(statuses) {
+		t.Error("TestAPIRuns: Expected", repr.String(expected), "got", repr.String(statuses))
+	}
+
+	var logs []apiTaskLog
+	apiRequest(t, server, "GET", "/runs/1/logs?task=fails", "", "", &logs)
+	if len(logs) != 1 || repr.String(logs[0].Output) != repr.String([]string{"broken"}) {
+		t.Error("TestAPIRuns: Expected the output of the failed task, got", repr.String(logs))
+	}
+
+	// a fatal error fails the run (instead of exiting)
+	request, _ := json.Marshal(apiRunRequest{Yaml: "tasks: [not valid"})
+	apiRequest(t, server, "POST", "/runs", "application/json", string(request), &item)
+	item = waitForRun(t, server, item.ID)
+	if item.Status != "error" || item.Error == "" {
+		t.Error("TestAPIRuns: Expected an invalid yaml to fail the run, got", repr.String(item))
+	}
+
+	// a fatal error within a task fails the run (the server and the other tasks carry on)
+	logDir, err := ioutil.TempDir("", "bashful-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(logDir)
+	os.Mkdir(filepath.Join(logDir, "broken.log"), 0755)
+	request, _ = json.Marshal(apiRunRequest{Yaml: "config:\n  log-dir: " + logDir + "\ntasks:\n  - name: broken\n    cmd: \"true\"\n  - name: after\n    cmd: \"true\"\n"})
+	apiRequest(t, server, "POST", "/runs", "application/json", string(request), &item)
+	item = waitForRun(t, server, item.ID)
+	if item.Status != "error" || !strings.Contains(item.Error, "Unable to create task log") || item.Result == nil || item.Result.Tasks[0].Status != "failed" {
+		t.Error("TestAPIRuns: Expected a task that cannot log to fail the run, got", repr.String(item))
+	}
+
+	var runs []apiRun
+	apiRequest(t, server, "GET", "/runs", "", "", &runs)
+	if len(runs) != 3 {
+		t.Error("TestAPIRuns: Expected all runs to be listed, got", repr.String(runs))
+	}
+
+	if code := apiRequest(t, server, "POST", "/runs", "application/json", `{"path": "/does/not/exist.yml"}`, nil); code != http.StatusBadRequest {
+		t.Error("TestAPIRuns: Expected a missing yaml file to be rejected, got", code)
+	}
+	if code := apiRequest(t, server, "GET", "/runs/99", "", "", nil); code != http.StatusNotFound {
+		t.Error("TestAPIRuns: Expected an unknown run to be not found, got", code)
+	}
+}
+
+func TestAPICancel(t *testing.T) {
+	setServing(true)
+	defer setServing(false)
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	scr.SetRenderer(newVirtualTerminal(80, 24))
+
+	server := httptest.NewServer(newAPIServer("secret"))
+	defer server.Close()
+
+	if code := apiRequest(t, server, "GET", "/runs", "", "", nil); code != http.StatusUnauthorized {
+		t.Error("TestAPICancel: Expected a request without a token to be unauthorized, got", code)
+	}
+
+	slow := `{"yaml": "tasks:\n  - name: slow\n    cmd: sleep 10\n  - name: never\n    cmd: \"true\"\n"}`
+	var running, queued apiRun
+	apiRequest(t, server, "POST", "/runs?token=secret", "application/json", slow, &running)
+	apiRequest(t, server, "POST", "/runs?token=secret", "application/json", slow, &queued)
+
+	// the queued run never starts
+	apiRequest(t, server, "DELETE", "/runs/"+queued.ID+"?token=secret", "", "", &queued)
+	if queued.Status != "cancelled" {
+		t.Error("TestAPICancel: Expected the queued run to be cancelled, got", repr.String(queued))
+	}
+
+	for start := time.Now(); running.Status != "running" && time.Since(start) < 5*time.Second; time.Sleep(20 * time.Millisecond) {
+		apiRequest(t, server, "GET", "/runs/"+running.ID+"?token=secret", "", "", &running)
+	}
+	start := time.Now()
+	apiRequest(t, server, "POST", "/runs/"+running.ID+"/cancel?token=secret", "", "", nil)
+
+	var item apiRun
+	for item.Status == "" || item.Status == "running" {
+		apiRequest(t, server, "GET", "/runs/"+running.ID+"?token=secret", "", "", &item)
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("TestAPICancel: Expected the running run to stop")
+		}
+	}
+	var statuses bytes.Buffer
+	for _, task := range item.Result.Tasks {
+		statuses.WriteString(task.Name + ":" + task.Status + " ")
+	}
+	if item.Status != "cancelled" || statuses.String() != "slow:cancelled never:not-run " {
+		t.Error("TestAPICancel: Expected the running run to be cancelled, got", item.Status, statuses.String())
+	}
+}
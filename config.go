@@ -1,12 +1,13 @@
 package main
 
 import (
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"os"
 	"path"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,31 +26,82 @@ var config struct {
 	// TaskConfigs is a list of task definitions and their metadata
 	TaskConfigs []TaskConfig `yaml:"tasks"`
 
+	// Theme is the set of templates, glyphs, and symbols used to draw the screen
+	Theme ThemeConfig `yaml:"theme"`
+
 	// CachePath is the dir path to place any temporary files
 	CachePath string
 
 	// logCachePath is the dir path to place temporary logs
 	logCachePath string
 
-	// etaCachePath is the file path for per-task ETA values (derived from a tasks CmdString)
+	// etaCachePath is the file path for per-task ETA values (keyed by the identity of each task)
 	etaCachePath string
 
+	// legacyEtaCachePath is the file path for per-task ETA values written by older releases (see loadEtaCache)
+	legacyEtaCachePath string
+
 	// downloadCachePath is the dir path to place downloaded resources (from url references)
 	downloadCachePath string
 
 	// totalEtaSeconds is the calculated ETA given the tree of tasks to execute
 	totalEtaSeconds float64
 
-	// commandTimeCache is the task CmdString-to-ETASeconds for any previously run command (read from etaCachePath)
-	commandTimeCache map[string]time.Duration
+	// totalEtaLowSeconds and totalEtaHighSeconds bound the calculated ETA (given the spread of previous runtimes)
+	totalEtaLowSeconds  float64
+	totalEtaHighSeconds float64
+
+	// etaCache holds the runtimes of every previously run task (read from etaCachePath)
+	etaCache etaCacheFile
+
+	// prunedTasks are the tasks that will not run given the cli tag and rerun options (listed as skipped on reports)
+	prunedTasks []prunedTask
+}
+
+// prunedTask is a task configuration that was removed from the run by the cli options (tags or rerun-failed)
+type prunedTask struct {
+	// Group is the name of the parallel task the pruned task belonged to (empty for a top-level task)
+	Group string
+
+	// Config is the configuration of the pruned task
+	Config TaskConfig
+
+	// Reason describes why the task will not run (shown on reports and events)
+	Reason string
 }
 
+const (
+	// prunedByTags is the reason given for tasks pruned by the 'tags' and 'only-tags' options
+	prunedByTags = "not matching the given tags"
+
+	// prunedByRerun is the reason given for tasks pruned by the 'rerun-failed' option
+	prunedByRerun = "succeeded in the previous run"
+)
+
 // CliOptions is the exhaustive set of all command line options available on bashful
 type CliOptions struct {
 	RunTags                []string
 	RunTagSet              mapset.Set
 	ExecuteOnlyMatchedTags bool
 	Args                   []string
+
+	// PlainUI indicates that output should be append-only lines without ansi cursor movement (e.g. for CI logs or pipes)
+	PlainUI bool
+
+	// Interactive indicates that key presses should be read to navigate, inspect, and cancel tasks while running
+	Interactive bool
+
+	// Reports is the report format ('junit' or 'tap') to file path for every report that should be written after the run
+	Reports map[string]string
+
+	// ResultFile is the path to write a json document describing the outcome of the run and every task (even if the run is aborted)
+	ResultFile string
+
+	// YamlPath is the absolute path of the yaml file being run (empty when the yaml is not read from a file)
+	YamlPath string
+
+	// RerunTasks is the set of task identities (see TaskConfig.identify) that should run, pruning all others (nil runs every task)
+	RerunTasks mapset.Set
 }
 
 // OptionsConfig is the set of values to be applied to all tasks or affect general behavior
@@ -72,24 +124,57 @@ type OptionsConfig struct {
 	// ColorError is the color of the vertical progress bar when the task has failed (# in the 256 palett)
 	ColorError int `yaml:"error-status-color"`
 
+	// DefaultEta is the expected runtime in seconds of a command that never ran before and is unlike any command that has (0 leaves the runtime unknown)
+	DefaultEta float64 `yaml:"default-eta"`
+
 	// EventDriven indicates if the screen should be updated on any/all task stdout/stderr events or on a polling schedule
 	EventDriven bool `yaml:"event-driven"`
 
 	// ExecReplaceString is a char or short string that is replaced with the temporary executable path when using the 'url' task config option
 	ExecReplaceString string `yaml:"exec-replace-pattern"`
 
+	// FailureReportLines is the number of the last stdout/stderr lines of each failed task shown on the failure report (0 shows none)
+	FailureReportLines int `yaml:"failure-report-lines"`
+
+	// HistoryRetention is the number of past runs kept in the run history within the cache dir (0 disables the history)
+	HistoryRetention int `yaml:"history-retention"`
+
+	// FrameRate is the most number of times per second that the screen should be redrawn from task events (0 indicates no limit)
+	FrameRate float64 `yaml:"frame-rate"`
+
 	// IgnoreFailure indicates when no errors should be registered (all task command non-zero return codes will be treated as a zero return code)
 	IgnoreFailure bool `yaml:"ignore-failure"`
 
+	// LogDir is the dir path to retain the full output of each task (one file per task, along with an index file)
+	LogDir string `yaml:"log-dir"`
+
+	// LogColors indicates if the logs should keep ansi color values ('colorize') or be written as plain text ('strip')
+	LogColors string `yaml:"log-colors"`
+
 	// LogPath is simply the filepath to write all main log entries
 	LogPath string `yaml:"log-path"`
 
-	// MaxParallelCmds indicates the most number of parallel commands that should be run at any one time
-	MaxParallelCmds int `yaml:"max-parallel-commands"`
+	// MaxParallelCmds indicates the most number of parallel commands that should be run at any one time (resolved from MaxParallelSetting)
+	MaxParallelCmds int `yaml:"-"`
+
+	// MaxParallelSetting is the 'max-parallel-commands' option as given: a number, 'auto' (the number of cpus), or an expression of the number of cpus (e.g. 'cpus-1' or 'cpus*2')
+	MaxParallelSetting string `yaml:"max-parallel-commands"`
+
+	// OutputLines is the number of screen rows reserved below a running task to show a rolling tail of its stdout/stderr
+	OutputLines int `yaml:"output-lines"`
+
+	// PreserveColors indicates that the ansi color values from task stdout/stderr should be shown (instead of recoloring each line)
+	PreserveColors bool `yaml:"preserve-colors"`
 
 	// ReplicaReplaceString is a char or short string that is replaced with values given by a tasks "for-each" configuration
 	ReplicaReplaceString string `yaml:"replica-replace-pattern"`
 
+	// Resources is the number of commands that may use each named resource at any one time (see the 'uses' task option)
+	Resources map[string]int `yaml:"resources"`
+
+	// Schedule is the order the child tasks of a parallel task are started in: 'fifo' (as listed), 'longest-first', or 'shortest-first' (by eta)
+	Schedule string `yaml:"schedule"`
+
 	// ShowSummaryErrors places the total number of errors in the summary footer
 	ShowSummaryErrors bool `yaml:"show-summary-errors"`
 
@@ -111,12 +196,21 @@ type OptionsConfig struct {
 	// ShowTaskOutput shows or hides a tasks command stdout/stderr while running
 	ShowTaskOutput bool `yaml:"show-task-output"`
 
+	// ShowTimingReport shows or hides a table of all task runtimes (slowest first, compared with the ETA from previous runs) after program execution
+	ShowTimingReport bool `yaml:"show-timing-report"`
+
 	// StopOnFailure indicates to halt further program execution if a task command has a non-zero return code
 	StopOnFailure bool `yaml:"stop-on-failure"`
 
 	// SingleLineDisplay indicates to show all bashful output in a single line (instead of a line per task + a summary line)
 	SingleLineDisplay bool `yaml:"single-line"`
 
+	// ThrottleLoad holds back starting new commands while the 1-minute load average per cpu is above this value (0 disables the throttle)
+	ThrottleLoad float64 `yaml:"throttle-load"`
+
+	// ThrottleMemory holds back starting new commands while more than this percent of memory is in use (0 disables the throttle)
+	ThrottleMemory float64 `yaml:"throttle-memory"`
+
 	// UpdateInterval is the time in seconds that the screen should be refreshed (only if EventDriven=false)
 	UpdateInterval float64 `yaml:"update-interval"`
 }
@@ -131,9 +225,16 @@ func NewOptionsConfig() (obj OptionsConfig) {
 	obj.ColorSuccess = 10
 	obj.EventDriven = true
 	obj.ExecReplaceString = "<exec>"
+	obj.FailureReportLines = 20
+	obj.FrameRate = 30
 	obj.IgnoreFailure = false
+	obj.LogColors = "colorize"
 	obj.MaxParallelCmds = 4
+	obj.MaxParallelSetting = "4"
+	obj.OutputLines = 0
+	obj.PreserveColors = false
 	obj.ReplicaReplaceString = "<replace>"
+	obj.Schedule = scheduleFifo
 	obj.ShowFailureReport = true
 	obj.ShowSummaryErrors = false
 	obj.ShowSummaryFooter = true
@@ -141,6 +242,7 @@ func NewOptionsConfig() (obj OptionsConfig) {
 	obj.ShowSummaryTimes = true
 	obj.ShowTaskEta = false
 	obj.ShowTaskOutput = true
+	obj.ShowTimingReport = false
 	obj.StopOnFailure = true
 	obj.SingleLineDisplay = false
 	obj.UpdateInterval = -1
@@ -161,6 +263,7 @@ func (options *OptionsConfig) UnmarshalYAML(unmarshal func(interface{}) error) e
 	if options.SingleLineDisplay {
 		options.ShowSummaryFooter = false
 		options.CollapseOnCompletion = false
+		options.OutputLines = 0
 	}
 
 	// the global options must be available when parsing the task yaml (does order matter?)
@@ -173,6 +276,15 @@ type TaskConfig struct {
 	// Name is the display name of the task (if not provided, then CmdString is used)
 	Name string `yaml:"name"`
 
+	// ID is a stable identity for the task, used to find the runtimes of previous runs (see etaKey)
+	ID string `yaml:"id"`
+
+	// identity is the explicit ID, or the yaml file and path of names to the task (set before any substitutions)
+	identity string
+
+	// replicaOf is the identity of the 'for-each' task this task is a replica of (empty for a task that is not a replica)
+	replicaOf string
+
 	// CmdString is the bash command to invoke when "running" this task
 	CmdString string `yaml:"cmd"`
 
@@ -188,12 +300,24 @@ type TaskConfig struct {
 	// IgnoreFailure indicates when no errors should be registered (all task command non-zero return codes will be treated as a zero return code)
 	IgnoreFailure bool `yaml:"ignore-failure"`
 
+	// MaxParallel indicates the most number of child tasks that should be run at any one time (0 indicates only the max-parallel-commands limit)
+	MaxParallel int `yaml:"max-parallel"`
+
 	// Md5 is the expected hash value after digesting a downloaded file from a Url (only used with TaskConfig.Url)
 	Md5 string `yaml:"md5"`
 
+	// OutputLines is the number of screen rows reserved below the running task to show a rolling tail of its stdout/stderr
+	OutputLines int `yaml:"output-lines"`
+
 	// ParallelTasks is a list of child tasks that should be run in concurrently with one another
 	ParallelTasks []TaskConfig `yaml:"parallel-tasks"`
 
+	// PreserveColors indicates that the ansi color values from the task stdout/stderr should be shown (instead of recoloring each line)
+	PreserveColors bool `yaml:"preserve-colors"`
+
+	// Schedule is the order the child tasks are started in: 'fifo' (as listed), 'longest-first', or 'shortest-first' (by eta)
+	Schedule string `yaml:"schedule"`
+
 	// ShowTaskOutput shows or hides a tasks command stdout/stderr while running
 	ShowTaskOutput bool `yaml:"show-output"`
 
@@ -209,6 +333,9 @@ type TaskConfig struct {
 
 	// URL is the http/https link to a bash/executable resource
 	URL string `yaml:"url"`
+
+	// Uses is a list of named resources (see the 'resources' option) the command needs a slot of while running
+	Uses stringArray `yaml:"uses"`
 }
 
 // NewTaskConfig creates a new TaskConfig populated with sane default values (derived from the global OptionsConfig)
@@ -218,6 +345,9 @@ func NewTaskConfig() (obj TaskConfig) {
 	obj.ShowTaskOutput = config.Options.ShowTaskOutput
 	obj.EventDriven = config.Options.EventDriven
 	obj.CollapseOnCompletion = config.Options.CollapseOnCompletion
+	obj.OutputLines = config.Options.OutputLines
+	obj.PreserveColors = config.Options.PreserveColors
+	obj.Schedule = config.Options.Schedule
 
 	return obj
 }
@@ -236,6 +366,12 @@ func (taskConfig *TaskConfig) UnmarshalYAML(unmarshal func(interface{}) error) e
 	if config.Options.SingleLineDisplay {
 		taskConfig.ShowTaskOutput = false
 		taskConfig.CollapseOnCompletion = false
+		taskConfig.OutputLines = 0
+	}
+
+	// plain output is append-only, so every output line should be captured as it occurs
+	if config.Cli.PlainUI {
+		taskConfig.EventDriven = true
 	}
 
 	return nil
@@ -288,17 +424,24 @@ func removeOneValue(slice []float64, value float64) []float64 {
 	return slice
 }
 
+// cachePath returns the dir path to place any temporary files (by default '$(pwd)/.bashful')
+func cachePath() string {
+	if config.CachePath != "" {
+		return config.CachePath
+	}
+	cwd, err := os.Getwd()
+	checkError(err, "Unable to get CWD.")
+	return path.Join(cwd, ".bashful")
+}
+
 // readTimeCache fetches and reads a cache file from disk containing CmdString-to-ETASeconds. Note: this this must be done before fetching/parsing the run.yaml
 func readTimeCache() {
-	if config.CachePath == "" {
-		cwd, err := os.Getwd()
-		checkError(err, "Unable to get CWD.")
-		config.CachePath = path.Join(cwd, ".bashful")
-	}
+	config.CachePath = cachePath()
 
 	config.downloadCachePath = path.Join(config.CachePath, "downloads")
 	config.logCachePath = path.Join(config.CachePath, "logs")
-	config.etaCachePath = path.Join(config.CachePath, "eta")
+	config.etaCachePath = path.Join(config.CachePath, etaCacheName)
+	config.legacyEtaCachePath = path.Join(config.CachePath, legacyEtaCacheName)
 
 	// create the cache dirs if they do not already exist
 	if _, err := os.Stat(config.CachePath); os.IsNotExist(err) {
@@ -311,11 +454,9 @@ func readTimeCache() {
 		os.Mkdir(config.logCachePath, 0755)
 	}
 
-	config.commandTimeCache = make(map[string]time.Duration)
-	if doesFileExist(config.etaCachePath) {
-		err := Load(config.etaCachePath, &config.commandTimeCache)
-		checkError(err, "Unable to load command eta cache.")
-	}
+	var err error
+	config.etaCache, err = loadEtaCache(config.etaCachePath, config.legacyEtaCachePath)
+	checkError(err, "Unable to load command eta cache.")
 }
 
 // replaceArguments replaces the command line arguments in the given string
@@ -328,6 +469,37 @@ func replaceArguments(source string) string {
 	return replaced
 }
 
+// identify sets the identity of the task given the identity of its parent task (empty for a top-level task) and its index
+// within the parent (used for a task without a name). An explicit ID is used as is.
+func (taskConfig *TaskConfig) identify(parent string, index int) {
+	if taskConfig.ID != "" {
+		taskConfig.identity = taskConfig.ID
+		return
+	}
+
+	name := taskConfig.Name
+	if name == "" {
+		name = "#" + strconv.Itoa(index+1)
+	}
+	switch {
+	case parent != "":
+		taskConfig.identity = parent + "/" + name
+	case config.Cli.YamlPath != "":
+		taskConfig.identity = config.Cli.YamlPath + ":" + name
+	default:
+		taskConfig.identity = name
+	}
+}
+
+// etaKey is the key of the task within the eta cache: the identity of the task (or the name of a task that was not
+// created from the yaml config)
+func (taskConfig *TaskConfig) etaKey() string {
+	if taskConfig.identity == "" {
+		return taskConfig.Name
+	}
+	return taskConfig.identity
+}
+
 func (taskConfig *TaskConfig) inflate() (tasks []TaskConfig) {
 	taskConfig.CmdString = replaceArguments(taskConfig.CmdString)
 	if taskConfig.Name == "" {
@@ -348,6 +520,8 @@ func (taskConfig *TaskConfig) inflate() (tasks []TaskConfig) {
 				newConfig.Name = newConfig.CmdString
 			}
 			newConfig.Name = strings.Replace(newConfig.Name, config.Options.ReplicaReplaceString, replicaValue, -1)
+			newConfig.identity = taskConfig.identity + "[" + replicaValue + "]"
+			newConfig.replicaOf = taskConfig.identity
 			newConfig.CmdString = strings.Replace(newConfig.CmdString, config.Options.ReplicaReplaceString, replicaValue, -1)
 			newConfig.URL = strings.Replace(newConfig.URL, config.Options.ReplicaReplaceString, replicaValue, -1)
 
@@ -356,6 +530,11 @@ func (taskConfig *TaskConfig) inflate() (tasks []TaskConfig) {
 				newConfig.Tags[k] = strings.Replace(taskConfig.Tags[k], config.Options.ReplicaReplaceString, replicaValue, -1)
 			}
 
+			newConfig.Uses = make(stringArray, len(taskConfig.Uses))
+			for k := range taskConfig.Uses {
+				newConfig.Uses[k] = strings.Replace(taskConfig.Uses[k], config.Options.ReplicaReplaceString, replicaValue, -1)
+			}
+
 			// insert the copy after current index
 			tasks = append(tasks, newConfig)
 		}
@@ -435,12 +614,24 @@ func assembleIncludes(yamlString []byte) []byte {
 func parseRunYaml(yamlString []byte) {
 	// fetch and parse the run.yaml user file...
 	config.Options = NewOptionsConfig()
+	config.Theme = NewThemeConfig()
+	config.prunedTasks = nil
 
 	yamlString = assembleIncludes(yamlString)
 	err := yaml.Unmarshal(yamlString, &config)
 	checkError(err, "Error: Unable to parse given yaml")
 
 	config.Options.validate()
+	applyTheme(config.Theme)
+
+	// identify every task before names and commands are substituted
+	for i := range config.TaskConfigs {
+		taskConfig := &config.TaskConfigs[i]
+		taskConfig.identify("", i)
+		for j := range taskConfig.ParallelTasks {
+			taskConfig.ParallelTasks[j].identify(taskConfig.identity, j)
+		}
+	}
 
 	// duplicate tasks with for-each clauses
 	for i := 0; i < len(config.TaskConfigs); i++ {
@@ -473,6 +664,13 @@ func parseRunYaml(yamlString []byte) {
 		}
 	}
 
+	for _, taskConfig := range config.TaskConfigs {
+		taskConfig.validateUses()
+		for _, subTaskConfig := range taskConfig.ParallelTasks {
+			subTaskConfig.validateUses()
+		}
+	}
+
 	// child tasks should inherit parent config tags
 	for index := range config.TaskConfigs {
 		taskConfig := &config.TaskConfigs[index]
@@ -504,6 +702,7 @@ func parseRunYaml(yamlString []byte) {
 					continue
 				}
 				// this particular subtask does not have a matching tag: prune this task
+				config.prunedTasks = append(config.prunedTasks, prunedTask{Group: taskConfig.Name, Config: *subTaskConfig, Reason: prunedByTags})
 				taskConfig.ParallelTasks = append(taskConfig.ParallelTasks[:j], taskConfig.ParallelTasks[j+1:]...)
 				j--
 			}
@@ -511,6 +710,34 @@ func parseRunYaml(yamlString []byte) {
 			matchedTaskTags := config.Cli.RunTagSet.Intersect(taskConfig.TagSet)
 			if !subTasksWithActiveTag && len(matchedTaskTags.ToSlice()) == 0 && (len(taskConfig.Tags) > 0 || config.Cli.ExecuteOnlyMatchedTags) {
 				// this task does not have matching tags and there are no children with matching tags: prune this task
+				if taskConfig.CmdString != "" {
+					config.prunedTasks = append(config.prunedTasks, prunedTask{Config: *taskConfig, Reason: prunedByTags})
+				}
+				config.TaskConfigs = append(config.TaskConfigs[:i], config.TaskConfigs[i+1:]...)
+				i--
+			}
+		}
+	}
+
+	// prune the set of tasks that already succeeded in the previous run (see the 'rerun-failed' option)
+	if config.Cli.RerunTasks != nil {
+		for i := 0; i < len(config.TaskConfigs); i++ {
+			taskConfig := &config.TaskConfigs[i]
+
+			for j := 0; j < len(taskConfig.ParallelTasks); j++ {
+				subTaskConfig := &taskConfig.ParallelTasks[j]
+				if config.Cli.RerunTasks.Contains(subTaskConfig.identity) {
+					continue
+				}
+				config.prunedTasks = append(config.prunedTasks, prunedTask{Group: taskConfig.Name, Config: *subTaskConfig, Reason: prunedByRerun})
+				taskConfig.ParallelTasks = append(taskConfig.ParallelTasks[:j], taskConfig.ParallelTasks[j+1:]...)
+				j--
+			}
+
+			if len(taskConfig.ParallelTasks) == 0 && !config.Cli.RerunTasks.Contains(taskConfig.identity) {
+				if taskConfig.CmdString != "" {
+					config.prunedTasks = append(config.prunedTasks, prunedTask{Config: *taskConfig, Reason: prunedByRerun})
+				}
 				config.TaskConfigs = append(config.TaskConfigs[:i], config.TaskConfigs[i+1:]...)
 				i--
 			}
@@ -518,8 +745,53 @@ func parseRunYaml(yamlString []byte) {
 	}
 }
 
+// taskKey identifies a task by the name of the parallel task it belongs to (empty for a top-level task) and its own name
+func taskKey(group, name string) string {
+	return group + "/" + name
+}
+
 func (options *OptionsConfig) validate() {
 
+	if options.LogColors != "colorize" && options.LogColors != "strip" {
+		exitWithErrorMessage("Option 'log-colors' must be one of: colorize, strip")
+	}
+
+	if options.FailureReportLines < 0 {
+		exitWithErrorMessage("Option 'failure-report-lines' must not be negative")
+	}
+
+	if options.HistoryRetention < 0 {
+		exitWithErrorMessage("Option 'history-retention' must not be negative")
+	}
+
+	if !validSchedule(options.Schedule) {
+		exitWithErrorMessage("Option 'schedule' must be one of: " + strings.Join(schedules, ", "))
+	}
+
+	for name, count := range options.Resources {
+		if count < 1 {
+			exitWithErrorMessage("Option 'resources' must allow at least one command to use '" + name + "'")
+		}
+	}
+
+	count, err := parseParallelLimit(options.MaxParallelSetting, runtime.NumCPU())
+	if err != nil {
+		exitWithErrorMessage("Option 'max-parallel-commands' is invalid: " + err.Error())
+	}
+	options.MaxParallelCmds = count
+
+	if options.ThrottleLoad < 0 || options.ThrottleMemory < 0 || options.ThrottleMemory > 100 {
+		exitWithErrorMessage("Options 'throttle-load' and 'throttle-memory' must not be negative (and 'throttle-memory' is a percent)")
+	}
+
+	if options.DefaultEta < 0 {
+		exitWithErrorMessage("Option 'default-eta' must not be negative")
+	}
+
+	if options.FrameRate < 0 {
+		exitWithErrorMessage("Option 'frame-rate' must not be negative")
+	}
+
 	// ensure not too many nestings of parallel tasks has been configured
 	for _, taskConfig := range config.TaskConfigs {
 		for _, subTaskConfig := range taskConfig.ParallelTasks {
@@ -532,10 +804,36 @@ func (options *OptionsConfig) validate() {
 	}
 }
 
+// frameInterval is the shortest time between two screen frames drawn from task events (only event-driven tasks draw
+// frames from events). The frames drawn on every update-interval tick (spinners, etas, and tasks that are not
+// event-driven) are not limited by this.
+func (options *OptionsConfig) frameInterval() time.Duration {
+	if options.FrameRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / options.FrameRate)
+}
+
 func (taskConfig *TaskConfig) validate() {
 	if taskConfig.CmdString == "" && len(taskConfig.ParallelTasks) == 0 && taskConfig.URL == "" {
 		exitWithErrorMessage("Task '" + taskConfig.Name + "' misconfigured (A configured task must have at least 'cmd', 'url', or 'parallel-tasks' configured)")
 	}
+	if taskConfig.MaxParallel < 0 {
+		exitWithErrorMessage("Task '" + taskConfig.Name + "' misconfigured ('max-parallel' must not be negative)")
+	}
+	if !validSchedule(taskConfig.Schedule) {
+		exitWithErrorMessage("Task '" + taskConfig.Name + "' misconfigured ('schedule' must be one of: " + strings.Join(schedules, ", ") + ")")
+	}
+}
+
+// validateUses ensures every resource the task uses is listed in the 'resources' option. This is checked once for-each
+// clauses have been inflated (a resource name may be given in terms of the replica value, e.g. 'db-<replace>').
+func (taskConfig *TaskConfig) validateUses() {
+	for _, name := range taskConfig.Uses {
+		if _, ok := config.Options.Resources[name]; !ok {
+			exitWithErrorMessage("Task '" + taskConfig.Name + "' misconfigured (uses '" + name + "' which is not listed in the 'resources' option)")
+		}
+	}
 }
 
 // CreateTasks is responsible for reading all parsed TaskConfigs and generating a list of Task runtime objects to later execute
@@ -550,9 +848,16 @@ func CreateTasks() (finalTasks []*Task) {
 		finalTasks = append(finalTasks, task)
 	}
 
-	// now that all tasks have been inflated, set the total eta
+	// now that all tasks have been inflated, guess the runtime of new commands and set the total eta
+	estimateUnknownRuntimes(finalTasks)
+	config.totalEtaSeconds = 0
+	config.totalEtaLowSeconds = 0
+	config.totalEtaHighSeconds = 0
 	for _, task := range finalTasks {
 		config.totalEtaSeconds += task.EstimateRuntime()
+		low, high := task.EstimateRuntimeRange()
+		config.totalEtaLowSeconds += low
+		config.totalEtaHighSeconds += high
 	}
 
 	// replace the current config with the inflated list of final tasks
@@ -570,30 +875,8 @@ func ParseConfig(yamlString []byte) {
 
 	parseRunYaml(yamlString)
 
-	if config.Options.LogPath != "" {
+	if config.Options.LogPath != "" || config.Options.LogDir != "" {
 		setupLogging()
 	}
 
 }
-
-// Save encodes a generic object via Gob to the given file path
-func Save(path string, object interface{}) error {
-	file, err := os.Create(path)
-	if err == nil {
-		encoder := gob.NewEncoder(file)
-		encoder.Encode(object)
-	}
-	file.Close()
-	return err
-}
-
-// Load decodes via Gob the contents of the given file to an object
-func Load(path string, object interface{}) error {
-	file, err := os.Open(path)
-	if err == nil {
-		decoder := gob.NewDecoder(file)
-		err = decoder.Decode(object)
-	}
-	file.Close()
-	return err
-}
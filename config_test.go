@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"strings"
 	"testing"
-	"time"
 
 	"github.com/alecthomas/repr"
 	"github.com/spf13/afero"
@@ -132,12 +131,12 @@ tasks:
       - plug 2
 `
 	// load test time cache
-	config.commandTimeCache = make(map[string]time.Duration)
-	config.commandTimeCache["compile-something.sh 2"] = time.Duration(2 * time.Second)
-	config.commandTimeCache["compile-something.sh 4"] = time.Duration(4 * time.Second)
-	config.commandTimeCache["compile-something.sh 6"] = time.Duration(6 * time.Second)
-	config.commandTimeCache["compile-something.sh 9"] = time.Duration(9 * time.Second)
-	config.commandTimeCache["compile-something.sh 10"] = time.Duration(10 * time.Second)
+	config.etaCache = newEtaCache()
+	config.etaCache.Commands["compile-something.sh 2"] = &etaSamples{Successes: []float64{2}}
+	config.etaCache.Commands["compile-something.sh 4"] = &etaSamples{Successes: []float64{4}}
+	config.etaCache.Commands["compile-something.sh 6"] = &etaSamples{Successes: []float64{6}}
+	config.etaCache.Commands["compile-something.sh 9"] = &etaSamples{Successes: []float64{9}}
+	config.etaCache.Commands["compile-something.sh 10"] = &etaSamples{Successes: []float64{10}}
 
 	// load test config yaml
 	parseRunYaml([]byte(simpleYamlStr))
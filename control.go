@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// controlRequests receives every request made over the control socket, handled between task updates (see listenAndDisplay)
+	controlRequests = make(chan controlRequest)
+
+	// controlListener is the control socket of the current run (nil when there is none)
+	controlListener net.Listener
+)
+
+// controlTimeout is the longest a control request waits for the run to handle it (e.g. while assets are downloaded)
+const controlTimeout = 5 * time.Second
+
+// controlRequest is a single command received over the control socket (e.g. "cancel build app")
+type controlRequest struct {
+	command string
+	args    []string
+	reply   chan controlResponse
+}
+
+// controlResponse is the outcome of a control request, written back over the control socket as a line of json
+type controlResponse struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// controlSocketPath is the path of the unix domain socket within the cache dir that controls the current run
+func controlSocketPath() string {
+	return path.Join(cachePath(), "control.sock")
+}
+
+// startControlSocket listens for control requests (see 'bashful ctl') for the duration of the run. The run continues
+// without a control socket if one cannot be created (e.g. another run is using the same cache dir).
+func startControlSocket() {
+	socketPath := controlSocketPath()
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		logToMain("Another run is already using the control socket "+socketPath, errorFormat)
+		return
+	}
+	// the socket of a run that did not exit cleanly
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		logToMain("Unable to create the control socket: "+err.Error(), errorFormat)
+		return
+	}
+	controlListener = listener
+	go acceptControlRequests(listener)
+}
+
+// stopControlSocket stops listening for control requests and removes the control socket
+func stopControlSocket() {
+	if controlListener == nil {
+		return
+	}
+	controlListener.Close()
+	controlListener = nil
+}
+
+// acceptControlRequests serves every connection to the given control socket (one request per connection)
+func acceptControlRequests(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go serveControlRequest(conn)
+	}
+}
+
+// serveControlRequest reads a single request line from the given connection and writes back the response
+func serveControlRequest(conn net.Conn) {
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		json.NewEncoder(conn).Encode(controlResponse{Error: "no command given"})
+		return
+	}
+
+	request := controlRequest{command: fields[0], args: fields[1:], reply: make(chan controlResponse, 1)}
+	response := controlResponse{Error: "the run is busy (e.g. downloading assets), try again"}
+	select {
+	case controlRequests <- request:
+		response = <-request.reply
+	case <-time.After(controlTimeout):
+	}
+	json.NewEncoder(conn).Encode(response)
+}
+
+// sendControlRequest sends the given command to the run listening on the control socket and returns its response
+func sendControlRequest(socketPath string, command []string) (controlResponse, error) {
+	var response controlResponse
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return response, errors.New("no run in progress (unable to connect to " + socketPath + ")")
+	}
+	defer conn.Close()
+
+	_, err = fmt.Fprintln(conn, strings.Join(command, " "))
+	if err == nil {
+		err = json.NewDecoder(conn).Decode(&response)
+	}
+	return response, err
+}
+
+// handleControl acts on a single control request for the given (currently running) task
+func (task *Task) handleControl(request controlRequest, environment map[string]string) {
+	response := controlResponse{}
+	switch request.command {
+	case "status":
+		response.Output = controlStatus()
+
+	case "cancel":
+		name := strings.Join(request.args, " ")
+		if name == "" {
+			response.Error = "the name of the task to cancel must be given"
+			break
+		}
+		response.Error = "no running task named '" + name + "'"
+		for _, candidate := range allTasks {
+			for _, subTask := range append([]*Task{candidate}, candidate.Children...) {
+				if subTask.Config.Name == name && subTask.Command.Started && !subTask.Command.Complete {
+					subTask.Cancel()
+					response = controlResponse{Output: "Cancelled " + name}
+				}
+			}
+		}
+
+	case "pause":
+		interactive.paused = true
+		response.Output = "Paused (running tasks continue, no new tasks are started)"
+
+	case "resume":
+		interactive.paused = false
+		task.StartAvailableTasks(environment)
+		response.Output = "Resumed"
+
+	case "set-parallel":
+		if len(request.args) != 1 {
+			response.Error = "set-parallel requires a single number greater than zero, 'auto', or an expression of 'cpus'"
+			break
+		}
+		count, err := parseParallelLimit(request.args[0], runtime.NumCPU())
+		if err != nil {
+			response.Error = "set-parallel: " + err.Error()
+			break
+		}
+		config.Options.MaxParallelCmds = count
+		task.StartAvailableTasks(environment)
+		response.Output = "Running at most " + strconv.Itoa(count) + " commands in parallel"
+
+	default:
+		response.Error = "unknown command '" + request.command + "' (expected status, cancel <task>, pause, resume, or set-parallel <n>)"
+	}
+
+	if response.Error == "" {
+		logToMain("Control: "+request.command+" "+strings.Join(request.args, " "), infoFormat)
+	}
+	request.reply <- response
+
+	if config.Cli.PlainUI {
+		if response.Error == "" && request.command != "status" {
+			newScreen().Println(bold("[bashful]"), response.Output)
+		}
+	} else if !interactive.overlayShown() {
+		task.redrawFrame()
+	}
+}
+
+// controlStatus describes the current run and the state of every task
+func controlStatus() string {
+	var lines []string
+	state := "running"
+	if interactive.paused {
+		state = "paused"
+	}
+	lines = append(lines, fmt.Sprintf("Run %s: %s for %s, %d of %d tasks completed, %d running (max %d parallel)",
+		runID, state, showDuration(time.Since(startTime)), TaskStats.completedTasks, TaskStats.totalTasks, TaskStats.runningCmds, config.Options.MaxParallelCmds))
+
+	if len(config.Options.Resources) > 0 {
+		var names, usage []string
+		for name := range config.Options.Resources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			usage = append(usage, fmt.Sprintf("%s %d/%d", name, TaskStats.resourcesInUse[name], config.Options.Resources[name]))
+		}
+		lines = append(lines, "Resources in use: "+strings.Join(usage, ", "))
+	}
+	if reason := throttleReason(); reason != "" {
+		lines = append(lines, "Throttled: "+reason)
+	}
+
+	for _, task := range allTasks {
+		if task.Config.CmdString != "" {
+			lines = append(lines, fmt.Sprintf("  %-10s %s", controlTaskStatus(task), task.Config.Name))
+		}
+		if len(task.Children) > 0 && task.Config.CmdString == "" {
+			lines = append(lines, "  "+task.Config.Name)
+		}
+		for _, subTask := range task.Children {
+			lines = append(lines, fmt.Sprintf("    %-10s %s", controlTaskStatus(subTask), subTask.Config.Name))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// controlTaskStatus is the status of the given task as shown by 'bashful ctl status'
+func controlTaskStatus(task *Task) string {
+	status := taskStatus(task)
+	if status == "not-run" {
+		return "pending"
+	}
+	return status
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/repr"
+)
+
+// waitForControlStatus polls the control socket until the run status contains the given text
+func waitForControlStatus(t *testing.T, socketPath, text string) string {
+	var response controlResponse
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(20 * time.Millisecond) {
+		response, _ = sendControlRequest(socketPath, []string{"status"})
+		if strings.Contains(response.Output, text) {
+			return response.Output
+		}
+	}
+	t.Fatal("Expected the control status to contain", repr.String(text), "got", repr.String(response))
+	return ""
+}
+
+func TestControlSocket(t *testing.T) {
+	yamlStr := `
+config:
+  max-parallel-commands: 1
+  stop-on-failure: false
+tasks:
+  - name: Group
+    parallel-tasks:
+      - name: slow
+        cmd: sleep 10
+      - name: after
+        cmd: echo after
+`
+	dir, err := ioutil.TempDir("", "bashful-control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cachePathValue := config.CachePath
+	defer func() { config.CachePath = cachePathValue }()
+	config.CachePath = dir
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	scr.SetRenderer(newVirtualTerminal(80, 24))
+
+	socketPath := controlSocketPath()
+	done := make(chan bool)
+	go func() {
+		run([]byte(yamlStr), map[string]string{})
+		done <- true
+	}()
+
+	waitForControlStatus(t, socketPath, "running    slow")
+
+	// nothing new is started while paused, even when more commands are allowed
+	for _, command := range [][]string{{"pause"}, {"set-parallel", "2"}} {
+		if response, err := sendControlRequest(socketPath, command); err != nil || response.Error != "" {
+			t.Error("TestControlSocket: Expected", repr.String(command), "to succeed, got", repr.String(response), err)
+		}
+	}
+	status := waitForControlStatus(t, socketPath, "paused")
+	if !strings.Contains(status, "pending    after") || !strings.Contains(status, "(max 2 parallel)") {
+		t.Error("TestControlSocket: Expected a pending task while paused, got", repr.String(status))
+	}
+
+	if response, _ := sendControlRequest(socketPath, []string{"resume"}); response.Error != "" {
+		t.Error("TestControlSocket: Expected resume to succeed, got", repr.String(response))
+	}
+	waitForControlStatus(t, socketPath, "success    after")
+
+	for _, command := range [][]string{{"cancel", "missing"}, {"set-parallel", "0"}, {"bogus"}} {
+		if response, _ := sendControlRequest(socketPath, command); response.Error == "" {
+			t.Error("TestControlSocket: Expected", repr.String(command), "to fail, got", repr.String(response))
+		}
+	}
+
+	if response, _ := sendControlRequest(socketPath, []string{"cancel", "slow"}); response.Output != "Cancelled slow" {
+		t.Error("TestControlSocket: Expected the task to be cancelled, got", repr.String(response))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("TestControlSocket: Expected the run to finish once the slow task was cancelled")
+	}
+
+	for _, task := range allTasks[0].Children {
+		if task.Config.Name == "slow" && taskStatus(task) != "cancelled" {
+			t.Error("TestControlSocket: Expected the slow task to be cancelled, got", taskStatus(task))
+		}
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Error("TestControlSocket: Expected the control socket to be removed after the run")
+	}
+	if _, err := sendControlRequest(socketPath, []string{"status"}); err == nil {
+		t.Error("TestControlSocket: Expected no run to be in progress")
+	}
+}
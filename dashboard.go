@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboard is a small web ui (see the 'serve' option) showing the task tree, statuses, and etas, along with live task
+// output streamed as server-sent events (from the same events written to the event stream)
+type dashboard struct {
+	// token is the value every request must provide (as a 'token' query value or a bearer authorization header), if set
+	token string
+
+	// lock guards clients, as events are published from concurrently running tasks
+	lock sync.Mutex
+
+	// clients are the channels of every connected server-sent event stream
+	clients map[chan []byte]bool
+
+	// mux routes all dashboard requests
+	mux *http.ServeMux
+}
+
+// dashboardState is a snapshot of the run as shown on the dashboard
+type dashboardState struct {
+	RunID     string          `json:"run-id"`
+	Status    string          `json:"status"`
+	StartTime time.Time       `json:"start-time"`
+	Runtime   float64         `json:"runtime-seconds"`
+	Eta       float64         `json:"eta-seconds"`
+	Tasks     []dashboardTask `json:"tasks"`
+}
+
+// dashboardTask is a single task (and any child tasks) as shown on the dashboard
+type dashboardTask struct {
+	Name     string          `json:"name"`
+	Command  string          `json:"command,omitempty"`
+	Status   string          `json:"status"`
+	Eta      float64         `json:"eta-seconds,omitempty"`
+	Runtime  float64         `json:"runtime-seconds,omitempty"`
+	Children []dashboardTask `json:"children,omitempty"`
+}
+
+// runSnapshot is the state of the current run as served over http. The tasks are changed by the run as it goes, so the
+// run publishes a copy of their state from time to time (see publishSnapshot).
+type runSnapshot struct {
+	// result describes the run and every task (as served by the api server)
+	result runResult
+
+	// dashboard is the run as shown on the dashboard
+	dashboard dashboardState
+
+	// commands are all tasks (and child tasks) with a command
+	commands []snapshotCommand
+}
+
+// snapshotCommand is the state of a single task command within a runSnapshot (the output is read from the task, as it
+// is captured under a lock)
+type snapshotCommand struct {
+	task     *Task
+	name     string
+	group    string
+	complete bool
+}
+
+var (
+	// snapshotLock guards snapshotsEnabled and snapshot, as the snapshot is served concurrently with the run
+	snapshotLock sync.Mutex
+
+	// snapshotsEnabled indicates that the state of the run is served over http (see publishSnapshot)
+	snapshotsEnabled bool
+
+	// snapshot is the latest state of the current run published by the run
+	snapshot runSnapshot
+)
+
+// dashboardClientBuffer is the number of events queued for a single client before further events are dropped (a
+// client that falls behind can always fetch the current state)
+const dashboardClientBuffer = 1000
+
+// newDashboard creates a dashboard that is notified of every run event published
+func newDashboard(token string) *dashboard {
+	board := &dashboard{token: token, clients: make(map[chan []byte]bool), mux: http.NewServeMux()}
+	board.mux.HandleFunc("/", board.serveIndex)
+	board.mux.HandleFunc("/api/state", board.serveState)
+	board.mux.HandleFunc("/api/log", board.serveLog)
+	board.mux.HandleFunc("/api/events", board.serveEvents)
+	subscribeEvents(board.publish)
+	enableSnapshots()
+	return board
+}
+
+// localAddress returns the given listen address, where an address without a host (e.g. ':8080') only listens on localhost
+func localAddress(address string) string {
+	if strings.HasPrefix(address, ":") {
+		return "127.0.0.1" + address
+	}
+	return address
+}
+
+// serveDashboard hosts a new dashboard on the given address (see localAddress)
+func serveDashboard(address, token string) {
+	address = localAddress(address)
+	listener, err := net.Listen("tcp", address)
+	checkError(err, "Unable to serve the dashboard on "+address)
+
+	fmt.Println(bold("Serving the dashboard on http://" + listener.Addr().String()))
+	go http.Serve(listener, newDashboard(token))
+}
+
+// authorized indicates if the given request provides the given token (as a 'token' query value or a bearer
+// authorization header). Every request is authorized when there is no token.
+func authorized(request *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	given := request.URL.Query().Get("token")
+	if header := request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		given = strings.TrimPrefix(header, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// ServeHTTP checks the token of the given request before serving it
+func (board *dashboard) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(request, board.token) {
+		http.Error(writer, "a valid token is required", http.StatusUnauthorized)
+		return
+	}
+	board.mux.ServeHTTP(writer, request)
+}
+
+// publish queues the given event for every connected client
+func (board *dashboard) publish(event runEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	board.lock.Lock()
+	defer board.lock.Unlock()
+	for client := range board.clients {
+		select {
+		case client <- data:
+		default:
+		}
+	}
+}
+
+// serveIndex serves the dashboard page
+func (board *dashboard) serveIndex(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != "/" {
+		http.NotFound(writer, request)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(writer, dashboardPage)
+}
+
+// serveState serves the latest snapshot of the run and every task as json
+func (board *dashboard) serveState(writer http.ResponseWriter, request *http.Request) {
+	state := currentSnapshot().dashboard
+	if state.Tasks == nil {
+		state.Tasks = []dashboardTask{}
+	}
+	if state.Status == "running" && !state.StartTime.IsZero() {
+		state.Runtime = time.Since(state.StartTime).Seconds()
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(state)
+}
+
+// newDashboardTask describes the given task (and all child tasks) as shown on the dashboard
+func newDashboardTask(task *Task) dashboardTask {
+	item := dashboardTask{Name: task.Config.Name, Command: task.Config.CmdString, Status: taskStatus(task)}
+	if item.Status == "not-run" && !runCompletedPublished {
+		item.Status = "pending"
+	}
+	if task.Command.EstimatedRuntime > 0 {
+		item.Eta = task.Command.EstimatedRuntime.Seconds()
+	}
+	if task.Command.Complete {
+		item.Runtime = task.Command.StopTime.Sub(task.Command.StartTime).Seconds()
+	} else if task.Command.Started {
+		item.Runtime = time.Since(task.Command.StartTime).Seconds()
+	}
+
+	if len(task.Children) > 0 {
+		// a parallel task is described by its children
+		item.Status = "pending"
+		for _, subTask := range task.Children {
+			child := newDashboardTask(subTask)
+			item.Children = append(item.Children, child)
+			if child.Status != "pending" && child.Status != "not-run" {
+				item.Status = "running"
+			}
+		}
+		if !task.groupStopTime.IsZero() {
+			item.Status = "success"
+			if len(task.failedTasks) > 0 {
+				item.Status = "failed"
+			}
+			item.Runtime = task.groupStopTime.Sub(task.groupStartTime).Seconds()
+		}
+	}
+	return item
+}
+
+// serveLog serves the output of a finished task (named by the 'task' query value) as plain text
+func (board *dashboard) serveLog(writer http.ResponseWriter, request *http.Request) {
+	name := request.URL.Query().Get("task")
+	for _, command := range currentSnapshot().commands {
+		if command.name != name {
+			continue
+		}
+		if !command.complete {
+			http.Error(writer, "the task has not finished", http.StatusConflict)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range command.task.CapturedOutput() {
+			fmt.Fprintln(writer, stripEscapes(line))
+		}
+		return
+	}
+	http.NotFound(writer, request)
+}
+
+// serveEvents streams every run event published (from now on) as server-sent events
+func (board *dashboard) serveEvents(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client := make(chan []byte, dashboardClientBuffer)
+	board.lock.Lock()
+	board.clients[client] = true
+	board.lock.Unlock()
+	defer func() {
+		board.lock.Lock()
+		delete(board.clients, client)
+		board.lock.Unlock()
+	}()
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case data := <-client:
+			fmt.Fprintf(writer, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+// dashboardPage is the single page of the dashboard (the task tree is fetched from /api/state and updated from /api/events)
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>bashful</title>
+<style>
+  body { font-family: monospace; background: #1d1f21; color: #c5c8c6; margin: 2em; }
+  .task { padding: 2px 0; cursor: pointer; }
+  .group { margin-top: 0.5em; font-weight: bold; }
+  .child { margin-left: 2em; }
+  .status { display: inline-block; width: 6em; }
+  .success { color: #b5bd68; } .failed, .cancelled { color: #cc6666; } .running { color: #81a2be; }
+  .not-run, .pending, .skipped { color: #707880; }
+  .times { color: #707880; margin-left: 1em; }
+  pre { background: #111; padding: 0.5em; margin: 0.25em 0 0.5em 2em; max-height: 20em; overflow: auto; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h3>bashful <span id="run"></span></h3>
+<div id="tasks"></div>
+<script>
+var token = new URLSearchParams(location.search).get("token") || "";
+var query = token ? "?token=" + encodeURIComponent(token) : "";
+var output = {};
+var open = {};
+
+function key(group, name) { return (group || "") + "/" + name; }
+
+function escape(text) { return text.replace(/&/g, "&amp;").replace(/</g, "&lt;"); }
+
+function seconds(value) { return value ? value.toFixed(1) + "s" : ""; }
+
+function finished(task) { return task.status === "success" || task.status === "failed" || task.status === "cancelled"; }
+
+function showOutput(task, group, pre) {
+  var id = key(group, task.name);
+  pre.style.display = open[id] ? "block" : "none";
+  if (!open[id]) {
+    return;
+  }
+  if (finished(task) && !task.children) {
+    fetch("/api/log" + (query ? query + "&" : "?") + "task=" + encodeURIComponent(task.name))
+      .then(function(response) { return response.text(); })
+      .then(function(text) { pre.textContent = text; });
+  } else {
+    pre.textContent = (output[id] || []).join("\n");
+  }
+}
+
+function line(task, group, className) {
+  var row = document.createElement("div");
+  row.className = "task " + className;
+  row.innerHTML = '<span class="status ' + task.status + '">' + task.status + '</span>' + escape(task.name) +
+    '<span class="times">' + seconds(task["runtime-seconds"]) +
+    (task["eta-seconds"] ? " / eta " + seconds(task["eta-seconds"]) : "") + '</span>';
+  var pre = document.createElement("pre");
+  showOutput(task, group, pre);
+  row.onclick = function() {
+    open[key(group, task.name)] = !open[key(group, task.name)];
+    showOutput(task, group, pre);
+  };
+  return [row, pre];
+}
+
+function refresh() {
+  fetch("/api/state" + query).then(function(response) { return response.json(); }).then(function(state) {
+    document.getElementById("run").textContent = state["run-id"] + " " + state.status + " " + seconds(state["runtime-seconds"]) +
+      (state["eta-seconds"] ? " / eta " + seconds(state["eta-seconds"]) : "");
+    var container = document.getElementById("tasks");
+    container.innerHTML = "";
+    state.tasks.forEach(function(task) {
+      line(task, "", task.children ? "group" : "").forEach(function(node) { container.appendChild(node); });
+      (task.children || []).forEach(function(child) {
+        line(child, task.name, "child").forEach(function(node) { container.appendChild(node); });
+      });
+    });
+  });
+}
+
+var pending = null;
+var events = new EventSource("/api/events" + query);
+events.onmessage = function(message) {
+  var event = JSON.parse(message.data);
+  if (event.type === "task-output") {
+    var id = key(event.group, event.task);
+    output[id] = (output[id] || []).concat([event.line]).slice(-200);
+  }
+  if (!pending) {
+    pending = setTimeout(function() { pending = null; refresh(); }, 250);
+  }
+};
+refresh();
+setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
+
+// enableSnapshots starts publishing the state of every run (see publishSnapshot)
+func enableSnapshots() {
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+	snapshotsEnabled = true
+}
+
+// publishSnapshot copies the current state of the run to be served over http. This is only called by the run itself
+// (between task updates), and does nothing unless the run state is served.
+func publishSnapshot() {
+	snapshotLock.Lock()
+	enabled := snapshotsEnabled
+	snapshotLock.Unlock()
+	if !enabled {
+		return
+	}
+
+	current := runSnapshot{result: newRunResult(allTasks, time.Now()), commands: snapshotCommands(allTasks)}
+	current.dashboard = dashboardState{RunID: runID, Status: "running", StartTime: startTime, Eta: config.totalEtaSeconds, Tasks: []dashboardTask{}}
+	if runCompletedPublished {
+		current.dashboard.Status = current.result.Status
+	}
+	for _, task := range allTasks {
+		current.dashboard.Tasks = append(current.dashboard.Tasks, newDashboardTask(task))
+	}
+
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+	snapshot = current
+}
+
+// currentSnapshot returns the latest state of the run published (see publishSnapshot)
+func currentSnapshot() runSnapshot {
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+	return snapshot
+}
+
+// snapshotCommands describes every task (and child task) with a command
+func snapshotCommands(tasks []*Task) (commands []snapshotCommand) {
+	for _, task := range tasks {
+		for _, candidate := range append([]*Task{task}, task.Children...) {
+			if candidate.Config.CmdString == "" {
+				continue
+			}
+			command := snapshotCommand{task: candidate, name: candidate.Config.Name, complete: candidate.Command.Complete}
+			if candidate.parent != nil {
+				command.group = candidate.parent.Config.Name
+			}
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestDashboard(t *testing.T) {
+	defer func() { allTasks = nil }()
+
+	finished := NewTask(TaskConfig{Name: "finished", CmdString: "echo done"}, 1, "")
+	finished.Command.Started = true
+	finished.Command.Complete = true
+	finished.Command.ReturnCode = 0
+	finished.captureOutput(blue("done"))
+
+	group := NewTask(TaskConfig{Name: "Group"}, 1, "")
+	running := NewTask(TaskConfig{Name: "running", CmdString: "sleep 10"}, 1, "")
+	running.Command.Started = true
+	group.Children = []*Task{running, NewTask(TaskConfig{Name: "waiting", CmdString: "true"}, 1, "")}
+	allTasks = []*Task{finished, group}
+	runCompletedPublished = false
+
+	server := httptest.NewServer(newDashboard("secret"))
+	defer server.Close()
+	publishSnapshot()
+
+	get := func(path string) (int, string) {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+
+	if code, _ := get("/api/state"); code != http.StatusUnauthorized {
+		t.Error("TestDashboard: Expected a request without a token to be unauthorized, got", code)
+	}
+	if code, body := get("/?token=secret"); code != http.StatusOK || !strings.Contains(body, "EventSource") {
+		t.Error("TestDashboard: Expected the dashboard page, got", code)
+	}
+
+	_, body := get("/api/state?token=secret")
+	var state dashboardState
+	if err := json.Unmarshal([]byte(body), &state); err != nil {
+		t.Fatal("TestDashboard: Unable to parse state", repr.String(body))
+	}
+	var statuses []string
+	for _, task := range state.Tasks {
+		statuses = append(statuses, task.Name+":"+task.Status)
+		for _, child := range task.Children {
+			statuses = append(statuses, task.Name+"/"+child.Name+":"+child.Status)
+		}
+	}
+	expected := []string{"finished:success", "Group:running", "Group/running:running", "Group/waiting:pending"}
+	if repr.String(expected) != repr.String(statuses) {
+		t.Error("TestDashboard: Expected", repr.String(expected), "got", repr.String(statuses))
+	}
+
+	if code, body := get("/api/log?token=secret&task=finished"); code != http.StatusOK || body != "done\n" {
+		t.Error("TestDashboard: Expected the output of a finished task, got", code, repr.String(body))
+	}
+	if code, _ := get("/api/log?token=secret&task=running"); code != http.StatusConflict {
+		t.Error("TestDashboard: Expected no output for a running task, got", code)
+	}
+	if code, _ := get("/api/log?token=secret&task=missing"); code != http.StatusNotFound {
+		t.Error("TestDashboard: Expected no output for an unknown task, got", code)
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	server := httptest.NewServer(newDashboard(""))
+	defer server.Close()
+
+	request, _ := http.NewRequest("GET", server.URL+"/api/events", nil)
+	request.Header.Set("Authorization", "Bearer ignored")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Error("TestDashboardEvents: Expected an event stream, got", response.Header.Get("Content-Type"))
+	}
+
+	publishEvent(runEvent{Type: "task-output", Task: "compile", Stream: "stdout", Line: "building"})
+
+	line, err := bufio.NewReader(response.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event runEvent
+	if !strings.HasPrefix(line, "data: ") || json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event) != nil || event.Line != "building" {
+		t.Error("TestDashboardEvents: Expected the published event, got", repr.String(line))
+	}
+}
@@ -33,6 +33,13 @@ func getFilename(urlStr string) string {
 }
 
 func monitorDownload(requests map[*grab.Request][]*Task, response *grab.Response, waiter *sync.WaitGroup) {
+	defer waiter.Done()
+
+	// a fatal error leaves the asset unusable (see recoverFailure)
+	defer func() {
+		recoverFailure(recover())
+	}()
+
 	bar := uiprogress.AddBar(100)
 	bar.AppendFunc(func(b *uiprogress.Bar) string {
 
@@ -73,6 +80,11 @@ func monitorDownload(requests map[*grab.Request][]*Task, response *grab.Response
 		}
 		return fmt.Sprintf("%-25s", urlStr)
 	})
+	if config.Cli.PlainUI {
+		<-response.Done
+		fmt.Println(bold("["+getFilename(response.Request.URL().String())+"]"), "Downloaded", humanize.Bytes(uint64(response.BytesComplete())))
+	}
+
 	t := time.NewTicker(100 * time.Millisecond)
 	defer t.Stop()
 Loop:
@@ -80,9 +92,11 @@ Loop:
 		select {
 		case <-t.C:
 			bar.Set(int(100 * response.Progress()))
+			publishEvent(runEvent{Type: "download-progress", URL: response.Request.URL().String(), Bytes: response.BytesComplete(), TotalBytes: response.Size})
 
 		case <-response.Done:
 			bar.Set(100)
+			publishEvent(runEvent{Type: "download-completed", URL: response.Request.URL().String(), Bytes: response.BytesComplete(), TotalBytes: response.Size})
 			break Loop
 		}
 	}
@@ -102,9 +116,6 @@ Loop:
 	for _, task := range registry.requestToTask[response.Request] {
 		task.updateExec(expectedFilepath)
 	}
-
-	waiter.Done()
-
 }
 
 // AddRequest extracts all URLS configured for a given task (does not examine child tasks) and queues them for download
@@ -200,7 +211,9 @@ func DownloadAssets(tasks []*Task) {
 	uiprogress.LeftEnd = '|'
 	uiprogress.RightEnd = '|'
 
-	uiprogress.Start()
+	if !config.Cli.PlainUI {
+		uiprogress.Start()
+	}
 	respch := client.DoBatch(config.Options.MaxParallelCmds, allRequests...)
 	var waiter sync.WaitGroup
 	var responses []*grab.Response
@@ -212,7 +225,9 @@ func DownloadAssets(tasks []*Task) {
 	}
 
 	waiter.Wait()
-	uiprogress.Stop()
+	if !config.Cli.PlainUI {
+		uiprogress.Stop()
+	}
 
 	// verify no download errors
 	foundFailedAsset := false
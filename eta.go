@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// etaCacheVersion is the version of the eta cache format (a cache of an unknown version is discarded)
+	etaCacheVersion = 2
+
+	// etaCacheName and legacyEtaCacheName are the eta cache file names within the cache dir (the legacy cache is a gob
+	// encoded map of the last runtime of each command, as written by older releases)
+	etaCacheName       = "eta.json"
+	legacyEtaCacheName = "eta"
+
+	// etaSampleLimit is the most number of runtimes kept for each task and outcome (older runtimes are discarded)
+	etaSampleLimit = 20
+
+	// etaLowPercentile and etaHighPercentile bound the range of runtimes a command is expected to complete within
+	etaLowPercentile  = 0.1
+	etaHighPercentile = 0.9
+)
+
+// etaCacheFile is the eta cache document, written as indented json so that it can be inspected (or edited) by hand
+type etaCacheFile struct {
+	Version int `json:"version"`
+
+	// Tasks are the runtimes of every task, keyed by the identity of the task (see TaskConfig.etaKey)
+	Tasks map[string]*etaSamples `json:"tasks"`
+
+	// Commands are the runtimes of an older cache (keyed by command), each is claimed by the first task that runs the
+	// same command (and is then removed)
+	Commands map[string]*etaSamples `json:"commands,omitempty"`
+
+	// claimed are the commands claimed by a task during this run
+	claimed map[string]bool
+}
+
+// etaSamples is the rolling history of runtimes (in seconds, oldest first) of a single task, where the runtimes of
+// failed runs are kept apart from successful runs (a failure often stops early, or late on a timeout)
+type etaSamples struct {
+	Command   string    `json:"command,omitempty"`
+	Successes []float64 `json:"successes,omitempty"`
+	Failures  []float64 `json:"failures,omitempty"`
+}
+
+// newEtaCache creates an empty eta cache
+func newEtaCache() etaCacheFile {
+	return etaCacheFile{Version: etaCacheVersion, Tasks: make(map[string]*etaSamples), Commands: make(map[string]*etaSamples), claimed: make(map[string]bool)}
+}
+
+// record adds the given runtime to the history of successful or failed runs (keeping only the latest runtimes)
+func (samples *etaSamples) record(runtime time.Duration, success bool) {
+	samples.add(runtime.Seconds(), success)
+}
+
+// add appends the given runtime (in seconds) to the history of successful or failed runs (keeping only the latest runtimes)
+func (samples *etaSamples) add(seconds float64, success bool) {
+	history := &samples.Failures
+	if success {
+		history = &samples.Successes
+	}
+	*history = append(*history, seconds)
+	if len(*history) > etaSampleLimit {
+		*history = (*history)[len(*history)-etaSampleLimit:]
+	}
+}
+
+// merge appends all runtimes of the given samples (as if they ran after the runtimes already recorded)
+func (samples *etaSamples) merge(other *etaSamples) {
+	for _, seconds := range other.Successes {
+		samples.add(seconds, true)
+	}
+	for _, seconds := range other.Failures {
+		samples.add(seconds, false)
+	}
+	if other.Command != "" {
+		samples.Command = other.Command
+	}
+}
+
+// estimate returns the expected runtime (the median of recent successful runs) along with the range the runtime is
+// expected within (the 10th to 90th percentile). Failed runs are only considered when the command has never succeeded.
+func (samples *etaSamples) estimate() (eta, low, high time.Duration, ok bool) {
+	history := samples.Successes
+	if len(history) == 0 {
+		history = samples.Failures
+	}
+	if len(history) == 0 {
+		return 0, 0, 0, false
+	}
+
+	sorted := append([]float64{}, history...)
+	sort.Float64s(sorted)
+	seconds := func(value float64) time.Duration {
+		return time.Duration(value * float64(time.Second))
+	}
+	return seconds(percentile(sorted, 0.5)), seconds(percentile(sorted, etaLowPercentile)), seconds(percentile(sorted, etaHighPercentile)), true
+}
+
+// percentile returns the value at the given fraction (0-1) of the given sorted values (interpolating between values)
+func percentile(sorted []float64, fraction float64) float64 {
+	position := fraction * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
+
+// lookup returns the runtimes of the task with the given identity, claiming the runtimes of the given command from an
+// older cache if the task has none (nil indicates that the task has never run)
+func (cache *etaCacheFile) lookup(key, cmdString string) *etaSamples {
+	if samples, ok := cache.Tasks[key]; ok {
+		return samples
+	}
+	samples, ok := cache.Commands[cmdString]
+	if !ok {
+		return nil
+	}
+	claimed := &etaSamples{}
+	claimed.merge(samples)
+	claimed.Command = cmdString
+	cache.Tasks[key] = claimed
+	cache.claimed[cmdString] = true
+	return claimed
+}
+
+// recordRuntime adds the runtime of a finished task command to the eta cache
+func recordRuntime(key, cmdString string, runtime time.Duration, success bool) {
+	samples, ok := config.etaCache.Tasks[key]
+	if !ok {
+		samples = &etaSamples{}
+		config.etaCache.Tasks[key] = samples
+	}
+	samples.Command = cmdString
+	samples.record(runtime, success)
+}
+
+// readEtaCacheFile reads an eta cache from the given path: a json document (of any known version) or a legacy gob
+// encoded cache (where each runtime becomes a successful run of an unclaimed command)
+func readEtaCacheFile(path string) (etaCacheFile, error) {
+	cache := newEtaCache()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cache, err
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		legacy := make(map[string]time.Duration)
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy); err != nil {
+			return cache, err
+		}
+		for cmdString, runtime := range legacy {
+			cache.Commands[cmdString] = &etaSamples{Successes: []float64{runtime.Seconds()}}
+		}
+		return cache, nil
+	}
+
+	var document etaCacheFile
+	if err := json.Unmarshal(data, &document); err != nil {
+		return cache, err
+	}
+	switch document.Version {
+	case 1:
+		// the first json cache was keyed by command (written as 'commands')
+		document.Tasks = nil
+	case etaCacheVersion:
+	default:
+		return cache, nil
+	}
+	for key, samples := range document.Tasks {
+		if samples != nil {
+			cache.Tasks[key] = samples
+		}
+	}
+	for cmdString, samples := range document.Commands {
+		if samples != nil {
+			cache.Commands[cmdString] = samples
+		}
+	}
+	return cache, nil
+}
+
+// loadEtaCache reads the eta cache from the given path, or migrates the cache written by an older release (at the given
+// legacy path) if there is none
+func loadEtaCache(path, legacyPath string) (etaCacheFile, error) {
+	switch {
+	case doesFileExist(path):
+		return readEtaCacheFile(path)
+	case doesFileExist(legacyPath):
+		return readEtaCacheFile(legacyPath)
+	}
+	return newEtaCache(), nil
+}
+
+// saveEtaCache writes the given eta cache to the given path, without any commands claimed by a task (and removes any
+// cache written by an older release)
+func saveEtaCache(path, legacyPath string, cache etaCacheFile) error {
+	document := etaCacheFile{Version: etaCacheVersion, Tasks: cache.Tasks, Commands: make(map[string]*etaSamples)}
+	for cmdString, samples := range cache.Commands {
+		if !cache.claimed[cmdString] {
+			document.Commands[cmdString] = samples
+		}
+	}
+
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(path, append(data, '\n'), 0644)
+	}
+	if err == nil && doesFileExist(legacyPath) {
+		err = os.Remove(legacyPath)
+	}
+	return err
+}
+
+// readEtaCache reads the eta cache within the cache dir (see the 'cache eta' command)
+func readEtaCache() etaCacheFile {
+	cache, err := loadEtaCache(path.Join(cachePath(), etaCacheName), path.Join(cachePath(), legacyEtaCacheName))
+	checkError(err, "Unable to load command eta cache.")
+	return cache
+}
+
+// writeEtaCache writes the given eta cache within the cache dir (see the 'cache eta' command)
+func writeEtaCache(cache etaCacheFile) {
+	if _, err := os.Stat(cachePath()); os.IsNotExist(err) {
+		os.Mkdir(cachePath(), 0755)
+	}
+	err := saveEtaCache(path.Join(cachePath(), etaCacheName), path.Join(cachePath(), legacyEtaCacheName), cache)
+	checkError(err, "Unable to save command eta cache.")
+}
+
+// etaCacheListing describes every task in the given eta cache (and every unclaimed command of an older cache)
+func etaCacheListing(cache etaCacheFile) string {
+	lines := []string{fmt.Sprintf("%-9s %-20s %-16s %s", "ETA", "RANGE", "RUNS", "TASK")}
+	describe := func(name string, samples *etaSamples) {
+		eta, low, high, _ := samples.estimate()
+		runs := strconv.Itoa(len(samples.Successes)) + " ok"
+		if len(samples.Failures) > 0 {
+			runs += ", " + strconv.Itoa(len(samples.Failures)) + " failed"
+		}
+		lines = append(lines, fmt.Sprintf("%-9s %-20s %-16s %s", showDuration(eta), showDuration(low)+".."+showDuration(high), runs, name))
+	}
+
+	for _, key := range sortedKeys(cache.Tasks) {
+		describe(key, cache.Tasks[key])
+	}
+	for _, cmdString := range sortedKeys(cache.Commands) {
+		describe("(unclaimed command) "+cmdString, cache.Commands[cmdString])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sortedKeys returns the keys of the given eta cache entries in order
+func sortedKeys(entries map[string]*etaSamples) (keys []string) {
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renameEtaCacheEntry moves the runtimes of a task to a new identity (e.g. after a task was renamed or moved)
+func renameEtaCacheEntry(cache etaCacheFile, from, to string) error {
+	samples, ok := cache.Tasks[from]
+	if !ok {
+		return errors.New("no task '" + from + "' in the eta cache")
+	}
+	if _, ok := cache.Tasks[to]; ok {
+		return errors.New("the task '" + to + "' is already in the eta cache")
+	}
+	delete(cache.Tasks, from)
+	cache.Tasks[to] = samples
+	return nil
+}
+
+// importEtaCache adds all runtimes of the given (other) eta cache to the given eta cache
+func importEtaCache(cache, other etaCacheFile) {
+	for key, samples := range other.Tasks {
+		if _, ok := cache.Tasks[key]; !ok {
+			cache.Tasks[key] = &etaSamples{}
+		}
+		cache.Tasks[key].merge(samples)
+	}
+	for cmdString, samples := range other.Commands {
+		if _, ok := cache.Commands[cmdString]; !ok {
+			cache.Commands[cmdString] = &etaSamples{}
+		}
+		cache.Commands[cmdString].merge(samples)
+	}
+}
+
+// clearEtaCache removes the given tasks (or unclaimed commands) from the given eta cache, or every entry if none are given
+func clearEtaCache(cache etaCacheFile, keys []string) error {
+	if len(keys) == 0 {
+		for key := range cache.Tasks {
+			delete(cache.Tasks, key)
+		}
+		for cmdString := range cache.Commands {
+			delete(cache.Commands, cmdString)
+		}
+		return nil
+	}
+
+	for _, key := range keys {
+		_, isTask := cache.Tasks[key]
+		_, isCommand := cache.Commands[key]
+		if !isTask && !isCommand {
+			return errors.New("no task '" + key + "' in the eta cache")
+		}
+		delete(cache.Tasks, key)
+		delete(cache.Commands, key)
+	}
+	return nil
+}
+
+// commandTasks returns every task (and child task) with a command to run, in the order of the given tasks
+func commandTasks(tasks []*Task) (commands []*Task) {
+	for _, task := range tasks {
+		for _, candidate := range append([]*Task{task}, task.Children...) {
+			if candidate.Config.CmdString != "" || candidate.Config.URL != "" {
+				commands = append(commands, candidate)
+			}
+		}
+	}
+	return commands
+}
+
+// estimateUnknownRuntimes gives an expected runtime to every command that never ran before: the median runtime of the
+// other replicas of the same 'for-each' task, else of the tasks that ran the same command, else the 'default-eta' option
+func estimateUnknownRuntimes(tasks []*Task) {
+	commands := commandTasks(tasks)
+
+	// only runtimes of previous runs are considered (not other guesses)
+	replicas := map[string][]TaskCommand{}
+	for _, task := range commands {
+		if task.Config.replicaOf != "" && task.Command.EstimatedRuntime != -1 {
+			replicas[task.Config.replicaOf] = append(replicas[task.Config.replicaOf], task.Command)
+		}
+	}
+
+	for _, task := range commands {
+		if task.Command.EstimatedRuntime != -1 {
+			continue
+		}
+
+		similar := replicas[task.Config.replicaOf]
+		if len(similar) == 0 {
+			for _, key := range sortedKeys(config.etaCache.Tasks) {
+				samples := config.etaCache.Tasks[key]
+				if eta, low, high, known := samples.estimate(); known && samples.Command == task.Config.CmdString && task.Config.CmdString != "" {
+					similar = append(similar, TaskCommand{EstimatedRuntime: eta, EstimatedRuntimeLow: low, EstimatedRuntimeHigh: high})
+				}
+			}
+		}
+
+		switch {
+		case len(similar) > 0:
+			median := func(runtime func(TaskCommand) time.Duration) time.Duration {
+				var values []float64
+				for _, command := range similar {
+					values = append(values, float64(runtime(command)))
+				}
+				sort.Float64s(values)
+				return time.Duration(percentile(values, 0.5))
+			}
+			task.Command.EstimatedRuntime = median(func(command TaskCommand) time.Duration { return command.EstimatedRuntime })
+			task.Command.EstimatedRuntimeLow = median(func(command TaskCommand) time.Duration { return command.EstimatedRuntimeLow })
+			task.Command.EstimatedRuntimeHigh = median(func(command TaskCommand) time.Duration { return command.EstimatedRuntimeHigh })
+		case config.Options.DefaultEta > 0:
+			eta := time.Duration(config.Options.DefaultEta * float64(time.Second))
+			task.Command.EstimatedRuntime, task.Command.EstimatedRuntimeLow, task.Command.EstimatedRuntimeHigh = eta, eta, eta
+		}
+	}
+}
+
+// remainingRuntime returns how much longer the given command is expected to run given its full runtime (a complete
+// command takes no more time, and an overdue command is expected to complete at any moment)
+func remainingRuntime(command *TaskCommand, runtime time.Duration) time.Duration {
+	switch {
+	case command.Complete:
+		return 0
+	case command.Started && !command.StartTime.IsZero():
+		if remaining := runtime - time.Since(command.StartTime); remaining > 0 {
+			return remaining
+		}
+		return 0
+	}
+	return runtime
+}
+
+// progressPercent returns how much of the run is complete, weighting every command by its expected runtime (a running
+// command counts for as long as it has run, up to its expected runtime). Commands with an unknown runtime are weighted by
+// the average expected runtime of all others (or all commands equally when no runtimes are known).
+func progressPercent() float64 {
+	commands := commandTasks(allTasks)
+	if len(commands) == 0 {
+		if TaskStats.totalTasks == 0 {
+			return 0
+		}
+		return float64(TaskStats.completedTasks) * 100 / float64(TaskStats.totalTasks)
+	}
+
+	var known []float64
+	for _, task := range commands {
+		if task.Command.EstimatedRuntime > 0 {
+			known = append(known, task.Command.EstimatedRuntime.Seconds())
+		}
+	}
+	average := 1.0
+	if len(known) > 0 {
+		var sum float64
+		for _, value := range known {
+			sum += value
+		}
+		average = sum / float64(len(known))
+	}
+
+	var total, done float64
+	for _, task := range commands {
+		weight := average
+		if task.Command.EstimatedRuntime > 0 {
+			weight = task.Command.EstimatedRuntime.Seconds()
+		}
+		total += weight
+		done += weight - remainingRuntime(&task.Command, time.Duration(weight*float64(time.Second))).Seconds()
+	}
+
+	// the run is not complete until every command is (even if all running commands are overdue)
+	percent := done * 100 / total
+	if percent > 99.99 && TaskStats.completedTasks < TaskStats.totalTasks {
+		percent = 99.99
+	}
+	return percent
+}
+
+// remainingEta formats the time remaining until the whole run is expected to complete: the tasks run one after another,
+// so this is the remaining critical path of the current task plus the runtime of every pending task
+func remainingEta() string {
+	var eta, low, high float64
+	for _, task := range allTasks {
+		taskEta, taskLow, taskHigh := task.RemainingRuntime()
+		eta, low, high = eta+taskEta, low+taskLow, high+taskHigh
+	}
+	seconds := func(value float64) time.Duration {
+		return time.Duration(value * float64(time.Second))
+	}
+	return showEta(seconds(eta), seconds(low), seconds(high))
+}
+
+// showEta formats the given remaining time, along with the range it is expected within when previous runs varied
+func showEta(eta, low, high time.Duration) string {
+	if low < 0 {
+		low = 0
+	}
+	if eta < 0 || int64(high.Seconds()) <= int64(low.Seconds()) {
+		return showDuration(eta)
+	}
+	return showDuration(eta) + " (" + showDuration(low) + ".." + showDuration(high) + ")"
+}
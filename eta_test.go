@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestEtaSamples(t *testing.T) {
+	samples := &etaSamples{}
+	if _, _, _, ok := samples.estimate(); ok {
+		t.Error("TestEtaSamples: Expected no estimate without any runtimes")
+	}
+
+	// failures are only used while the command has never succeeded
+	samples.record(30*time.Second, false)
+	if eta, _, _, ok := samples.estimate(); !ok || eta != 30*time.Second {
+		t.Error("TestEtaSamples: Expected the failed runtime to be used, got", eta)
+	}
+
+	// a single slow (e.g. cold cache) run does not move the estimate far
+	for _, seconds := range []float64{10, 12, 11, 60, 9} {
+		samples.record(time.Duration(seconds*float64(time.Second)), true)
+	}
+	eta, low, high, _ := samples.estimate()
+	if eta != 11*time.Second || low != 9400*time.Millisecond || high != 40800*time.Millisecond {
+		t.Error("TestEtaSamples: Expected the median and the 10th-90th percentile, got", eta, low, high)
+	}
+	if len(samples.Failures) != 1 {
+		t.Error("TestEtaSamples: Expected failures to be kept apart, got", repr.String(samples))
+	}
+
+	for idx := 0; idx < etaSampleLimit+5; idx++ {
+		samples.record(time.Second, true)
+	}
+	if len(samples.Successes) != etaSampleLimit {
+		t.Error("TestEtaSamples: Expected only the latest runtimes to be kept, got", len(samples.Successes))
+	}
+}
+
+func TestEtaCacheMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bashful-eta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, legacyPath := filepath.Join(dir, "eta.json"), filepath.Join(dir, "eta")
+
+	file, err := os.Create(legacyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gob.NewEncoder(file).Encode(map[string]time.Duration{"make build": 3 * time.Second})
+	file.Close()
+
+	cache, err := loadEtaCache(path, legacyPath)
+	if err != nil || repr.String(cache.Commands) != repr.String(map[string]*etaSamples{"make build": {Successes: []float64{3}}}) {
+		t.Fatal("TestEtaCacheMigration: Expected the legacy cache to be migrated, got", repr.String(cache), err)
+	}
+
+	// a command of an older cache is claimed by the first task that runs it
+	if samples := cache.lookup("run.yml:build", "make build"); samples == nil || repr.String(samples.Successes) != repr.String([]float64{3}) {
+		t.Error("TestEtaCacheMigration: Expected the task to claim the command runtimes, got", repr.String(samples))
+	}
+	cache.Tasks["run.yml:build"].record(5*time.Second, false)
+	cache.Commands["make test"] = &etaSamples{Successes: []float64{7}}
+	if err := saveEtaCache(path, legacyPath, cache); err != nil {
+		t.Fatal(err)
+	}
+	if doesFileExist(legacyPath) {
+		t.Error("TestEtaCacheMigration: Expected the legacy cache to be removed")
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	expected := `{
+  "version": 2,
+  "tasks": {
+    "run.yml:build": {
+      "command": "make build",
+      "successes": [
+        3
+      ],
+      "failures": [
+        5
+      ]
+    }
+  },
+  "commands": {
+    "make test": {
+      "successes": [
+        7
+      ]
+    }
+  }
+}
+`
+	if string(data) != expected {
+		t.Error("TestEtaCacheMigration: Expected", repr.String(expected), "got", repr.String(string(data)))
+	}
+
+	reloaded, err := loadEtaCache(path, legacyPath)
+	if err != nil || len(reloaded.Tasks) != 1 || len(reloaded.Commands) != 1 || reloaded.Tasks["run.yml:build"].Command != "make build" {
+		t.Error("TestEtaCacheMigration: Expected the cache to be read back, got", repr.String(reloaded), err)
+	}
+
+	// the first json cache was keyed by command
+	ioutil.WriteFile(path, []byte(`{"version": 1, "commands": {"make build": {"successes": [1]}}}`), 0644)
+	if cache, err := loadEtaCache(path, legacyPath); err != nil || len(cache.Tasks) != 0 || len(cache.Commands) != 1 {
+		t.Error("TestEtaCacheMigration: Expected the commands of the first json cache to be kept, got", repr.String(cache), err)
+	}
+
+	// a cache of an unknown version is discarded
+	ioutil.WriteFile(path, []byte(`{"version": 99, "tasks": {"build": {"successes": [1]}}}`), 0644)
+	if cache, err := loadEtaCache(path, legacyPath); err != nil || len(cache.Tasks) != 0 || len(cache.Commands) != 0 {
+		t.Error("TestEtaCacheMigration: Expected an unknown version to be discarded, got", repr.String(cache), err)
+	}
+}
+
+func TestTaskIdentity(t *testing.T) {
+	yamlStr := `
+tasks:
+  - name: setup
+    cmd: ./setup.sh $1
+  - cmd: echo unnamed
+  - name: Compiling
+    parallel-tasks:
+      - name: compile <replace>
+        cmd: make <replace>
+        for-each: [a, b]
+      - id: linker
+        name: link
+        cmd: ld
+`
+	defer func() { config.Cli = CliOptions{} }()
+	config.Cli.YamlPath = "/src/run.yml"
+	config.Cli.Args = []string{"--fast"}
+	config.etaCache = newEtaCache()
+	config.etaCache.Tasks["/src/run.yml:setup"] = &etaSamples{Successes: []float64{4}}
+
+	parseRunYaml([]byte(yamlStr))
+	tasks := CreateTasks()
+
+	var actual []string
+	for _, task := range tasks {
+		for _, candidate := range append([]*Task{task}, task.Children...) {
+			actual = append(actual, candidate.Config.etaKey())
+		}
+	}
+	expected := []string{"/src/run.yml:setup", "/src/run.yml:#2", "/src/run.yml:Compiling", "/src/run.yml:Compiling/compile <replace>[a]", "/src/run.yml:Compiling/compile <replace>[b]", "linker"}
+	if repr.String(actual) != repr.String(expected) {
+		t.Error("TestTaskIdentity: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+
+	// the runtimes are found regardless of the arguments substituted in the command
+	if tasks[0].Config.CmdString != "./setup.sh --fast" || tasks[0].Command.EstimatedRuntime != 4*time.Second {
+		t.Error("TestTaskIdentity: Expected the eta of the task to be found, got", repr.String(tasks[0].Config.CmdString), tasks[0].Command.EstimatedRuntime)
+	}
+}
+
+func TestEtaCacheCommands(t *testing.T) {
+	cache := newEtaCache()
+	cache.Tasks["build"] = &etaSamples{Successes: []float64{10, 20, 30}}
+	cache.Commands["make test"] = &etaSamples{Successes: []float64{7}, Failures: []float64{1}}
+
+	if err := renameEtaCacheEntry(cache, "build", "compile"); err != nil || cache.Tasks["compile"] == nil || cache.Tasks["build"] != nil {
+		t.Error("TestEtaCacheCommands: Expected the task to be renamed, got", repr.String(cache.Tasks), err)
+	}
+	if err := renameEtaCacheEntry(cache, "missing", "other"); err == nil {
+		t.Error("TestEtaCacheCommands: Expected an error renaming a task that is not in the cache")
+	}
+
+	other := newEtaCache()
+	other.Tasks["compile"] = &etaSamples{Successes: []float64{40}}
+	other.Tasks["deploy"] = &etaSamples{Failures: []float64{5}}
+	importEtaCache(cache, other)
+	if repr.String(cache.Tasks["compile"].Successes) != repr.String([]float64{10, 20, 30, 40}) || cache.Tasks["deploy"] == nil {
+		t.Error("TestEtaCacheCommands: Expected the runtimes to be imported, got", repr.String(cache.Tasks))
+	}
+
+	expected := strings.Join([]string{
+		"ETA       RANGE                RUNS             TASK",
+		"00:00:25  00:00:13..00:00:37   4 ok             compile",
+		"00:00:05  00:00:05..00:00:05   0 ok, 1 failed   deploy",
+		"00:00:07  00:00:07..00:00:07   1 ok, 1 failed   (unclaimed command) make test",
+	}, "\n")
+	if actual := etaCacheListing(cache); actual != expected {
+		t.Error("TestEtaCacheCommands: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+
+	if err := clearEtaCache(cache, []string{"deploy", "make test"}); err != nil || len(cache.Tasks) != 1 || len(cache.Commands) != 0 {
+		t.Error("TestEtaCacheCommands: Expected the given entries to be cleared, got", repr.String(cache), err)
+	}
+	if err := clearEtaCache(cache, []string{"missing"}); err == nil {
+		t.Error("TestEtaCacheCommands: Expected an error clearing a task that is not in the cache")
+	}
+	if err := clearEtaCache(cache, nil); err != nil || len(cache.Tasks) != 0 {
+		t.Error("TestEtaCacheCommands: Expected every entry to be cleared, got", repr.String(cache), err)
+	}
+}
+
+func TestShowEta(t *testing.T) {
+	tests := map[string][3]time.Duration{
+		"00:01:00":                      {time.Minute, time.Minute, time.Minute},
+		"00:01:00 (00:00:50..00:01:30)": {time.Minute, 50 * time.Second, 90 * time.Second},
+		"00:00:05 (00:00:00..00:00:40)": {5 * time.Second, -5 * time.Second, 40 * time.Second},
+		"Overdue!":                      {-time.Second, -10 * time.Second, 10 * time.Second},
+	}
+	for expected, values := range tests {
+		if actual := showEta(values[0], values[1], values[2]); actual != expected {
+			t.Error("TestShowEta: Expected", repr.String(expected), "got", repr.String(actual))
+		}
+	}
+}
+
+func TestEstimateUnknownRuntimes(t *testing.T) {
+	yamlStr := `
+config:
+  default-eta: 5
+  max-parallel-commands: 2
+tasks:
+  - name: setup
+    cmd: ./setup.sh
+  - name: renamed
+    cmd: make lint
+  - name: Compiling
+    parallel-tasks:
+      - name: compile <replace>
+        cmd: make <replace>
+        for-each: [a, b, c]
+      - name: new
+        cmd: echo new
+`
+	defer func() { config.Cli = CliOptions{} }()
+	config.Cli.YamlPath = "/src/run.yml"
+	config.etaCache = newEtaCache()
+	config.etaCache.Tasks["/src/run.yml:setup"] = &etaSamples{Command: "./setup.sh", Successes: []float64{20}}
+	config.etaCache.Tasks["/src/run.yml:lint"] = &etaSamples{Command: "make lint", Successes: []float64{8}}
+	config.etaCache.Tasks["/src/run.yml:Compiling/compile <replace>[a]"] = &etaSamples{Successes: []float64{60}}
+	config.etaCache.Tasks["/src/run.yml:Compiling/compile <replace>[b]"] = &etaSamples{Successes: []float64{100}}
+
+	parseRunYaml([]byte(yamlStr))
+	allTasks = CreateTasks()
+	defer func() { allTasks = nil }()
+
+	var actual []time.Duration
+	for _, task := range commandTasks(allTasks) {
+		actual = append(actual, task.Command.EstimatedRuntime)
+	}
+	// a new replica is expected to take as long as the others, a renamed task as long as the same command did before
+	expected := []time.Duration{20 * time.Second, 8 * time.Second, 60 * time.Second, 100 * time.Second, 80 * time.Second, 5 * time.Second}
+	if repr.String(actual) != repr.String(expected) {
+		t.Error("TestEstimateUnknownRuntimes: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+	// setup, lint, then two commands at a time: a+b (100s), c+new following a (80s after 60s)
+	if config.totalEtaSeconds != 20+8+140 {
+		t.Error("TestEstimateUnknownRuntimes: Expected the total eta to include the guessed runtimes, got", config.totalEtaSeconds)
+	}
+
+	// the progress is weighted by runtime, and the eta follows what is still running and pending
+	commands := commandTasks(allTasks)
+	for _, task := range commands[:2] {
+		task.Command.Started, task.Command.Complete = true, true
+	}
+	commands[2].Command.Started, commands[2].Command.StartTime = true, time.Now().Add(-29500*time.Millisecond)
+	commands[3].Command.Started, commands[3].Command.StartTime = true, time.Now().Add(-29500*time.Millisecond)
+	if percent := progressPercent(); math.Abs(percent-100*(28+29.5+29.5)/273.0) > 0.01 {
+		t.Error("TestEstimateUnknownRuntimes: Expected the progress to be weighted by runtime, got", percent)
+	}
+	if eta := remainingEta(); eta != "00:01:50" {
+		t.Error("TestEstimateUnknownRuntimes: Expected the eta of the remaining critical path, got", eta)
+	}
+}
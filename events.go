@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// eventLock guards eventStream and eventSubscribers, as events are published from concurrently running tasks
+	eventLock sync.Mutex
+
+	// eventStream is where every run event is written as a line of json (see the 'events' option)
+	eventStream *eventWriter
+
+	// eventSubscribers are notified of every run event published (in addition to the event stream)
+	eventSubscribers []func(runEvent)
+
+	// runCompletedPublished indicates that the outcome of the current run was already published (it is published only once)
+	runCompletedPublished bool
+)
+
+// runEvent is a single thing that happened during the run, as written to the event stream (one json object per line)
+type runEvent struct {
+	Time       time.Time `json:"time"`
+	RunID      string    `json:"run-id"`
+	Type       string    `json:"type"`
+	Task       string    `json:"task,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	Line       string    `json:"line,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	ReturnCode *int      `json:"return-code,omitempty"`
+	Duration   float64   `json:"duration-seconds,omitempty"`
+	Eta        float64   `json:"eta-seconds,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	TotalBytes int64     `json:"total-bytes,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+// eventStreamBuffer is the number of events queued for the event stream before the stream is closed (a consumer that
+// falls this far behind would otherwise hold up the run)
+const eventStreamBuffer = 1000
+
+// eventWriter writes events to the event stream from its own goroutine, so a slow consumer never holds up the run
+type eventWriter struct {
+	// stream is where every event is written
+	stream io.WriteCloser
+
+	// lines are the encoded events waiting to be written
+	lines chan []byte
+
+	// done is closed once every queued event has been written (or the stream can no longer be written to) and the stream
+	// is closed
+	done chan bool
+}
+
+// newEventWriter starts writing the events queued for the given stream
+func newEventWriter(stream io.WriteCloser) *eventWriter {
+	writer := &eventWriter{stream: stream, lines: make(chan []byte, eventStreamBuffer), done: make(chan bool)}
+	go func() {
+		defer close(writer.done)
+		defer writer.stream.Close()
+		for line := range writer.lines {
+			if _, err := writer.stream.Write(line); err != nil {
+				logToMain("Unable to write to the event stream: "+err.Error(), errorFormat)
+				// the remaining events are discarded (the stream is closed once no more can be queued)
+				for range writer.lines {
+				}
+				return
+			}
+		}
+	}()
+	return writer
+}
+
+// close stops queueing events, waiting for the queued events to be written
+func (writer *eventWriter) close() {
+	close(writer.lines)
+	<-writer.done
+}
+
+// openEventStream connects the event stream to the given target: a file path, an open file descriptor ('fd:N'), or a
+// listening unix domain socket ('unix:path')
+func openEventStream(target string) error {
+	var stream io.WriteCloser
+	switch {
+	case strings.HasPrefix(target, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if err != nil {
+			return err
+		}
+		stream = os.NewFile(uintptr(fd), target)
+	case strings.HasPrefix(target, "unix:"):
+		conn, err := net.Dial("unix", strings.TrimPrefix(target, "unix:"))
+		if err != nil {
+			return err
+		}
+		stream = conn
+	default:
+		file, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		stream = file
+	}
+
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	eventStream = newEventWriter(stream)
+	return nil
+}
+
+// closeEventStream stops writing events (closing the event stream, if any)
+func closeEventStream() {
+	eventLock.Lock()
+	writer := eventStream
+	eventStream = nil
+	eventLock.Unlock()
+
+	if writer != nil {
+		writer.close()
+	}
+}
+
+// subscribeEvents registers the given function to be called with every event published (from any goroutine)
+func subscribeEvents(subscriber func(runEvent)) {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	eventSubscribers = append(eventSubscribers, subscriber)
+}
+
+// publishEvent queues the given event for the event stream and notifies all subscribers. A stream that falls too far
+// behind is closed (the run continues without it).
+func publishEvent(event runEvent) {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	if eventStream == nil && len(eventSubscribers) == 0 {
+		return
+	}
+
+	event.Time = time.Now()
+	event.RunID = runID
+
+	for _, subscriber := range eventSubscribers {
+		subscriber(event)
+	}
+
+	if eventStream != nil {
+		// the encoder writes each event as a single line
+		var line bytes.Buffer
+		encoder := json.NewEncoder(&line)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(event)
+
+		select {
+		case eventStream.lines <- line.Bytes():
+		default:
+			writer := eventStream
+			eventStream = nil
+			go func() {
+				logToMain("Closing the event stream (the consumer has fallen behind)", errorFormat)
+				// closing the stream stops any write in progress
+				writer.stream.Close()
+				writer.close()
+			}()
+		}
+	}
+}
+
+// taskEvent creates an event of the given type about the given task
+func taskEvent(eventType string, task *Task) runEvent {
+	event := runEvent{Type: eventType, Task: task.Config.Name}
+	if eventType == "task-queued" || eventType == "task-started" {
+		event.Command = task.Config.CmdString
+	}
+	if task.parent != nil {
+		event.Group = task.parent.Config.Name
+	}
+	return event
+}
+
+// publishTaskEvents publishes an event of the given type for every task (and child task) with a command
+func publishTaskEvents(eventType string, tasks []*Task) {
+	for _, task := range tasks {
+		if task.Config.CmdString != "" {
+			event := taskEvent(eventType, task)
+			if eventType == "task-queued" && task.Command.EstimatedRuntime > 0 {
+				event.Eta = task.Command.EstimatedRuntime.Seconds()
+			}
+			publishEvent(event)
+		}
+		publishTaskEvents(eventType, task.Children)
+	}
+}
+
+// publishTaskCompleted publishes the outcome of a task command that has finished running
+func publishTaskCompleted(task *Task, returnCode int) {
+	event := taskEvent("task-completed", task)
+	event.ReturnCode = &returnCode
+	event.Status = "success"
+	if returnCode != 0 && !task.Config.IgnoreFailure {
+		event.Status = "failed"
+	}
+	if task.isCancelled() {
+		event.Status = "cancelled"
+	}
+	event.Duration = task.Command.StopTime.Sub(task.Command.StartTime).Seconds()
+	publishEvent(event)
+}
+
+// publishRunStarted publishes the start of the run: every task that is queued to run and every task pruned by the cli options
+func publishRunStarted(tasks []*Task) {
+	publishEvent(runEvent{Type: "run-started", Eta: config.totalEtaSeconds})
+	publishTaskEvents("task-queued", tasks)
+	for _, pruned := range config.prunedTasks {
+		publishEvent(runEvent{Type: "task-skipped", Task: pruned.Config.Name, Group: pruned.Group, Message: pruned.Reason})
+	}
+}
+
+// publishRunCompleted publishes the outcome of the run (along with a skipped event for every task that did not run)
+func publishRunCompleted() {
+	if runID == "" || runCompletedPublished {
+		return
+	}
+	runCompletedPublished = true
+
+	var notRun []*Task
+	for _, task := range allTasks {
+		for _, candidate := range append([]*Task{task}, task.Children...) {
+			if candidate.Config.CmdString != "" && !candidate.Command.Started {
+				notRun = append(notRun, candidate)
+			}
+		}
+	}
+	for _, task := range notRun {
+		event := taskEvent("task-skipped", task)
+		event.Message = "not run"
+		publishEvent(event)
+	}
+
+	result := newRunResult(allTasks, time.Now())
+	publishEvent(runEvent{Type: "run-completed", Status: result.Status, Duration: result.Duration})
+}
+
+// publishTaskOutput publishes a single line of output from the given stream ('stdout' or 'stderr') of a task command
+func publishTaskOutput(task *Task, stream, line string) {
+	event := taskEvent("task-output", task)
+	event.Stream = stream
+	event.Line = stripEscapes(line)
+	publishEvent(event)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestEventStream(t *testing.T) {
+	yamlStr := `
+config:
+  stop-on-failure: false
+tasks:
+  - name: greet
+    cmd: echo hello
+  - name: Group
+    parallel-tasks:
+      - name: fails
+        cmd: echo broken >&2; false
+`
+	dir, err := ioutil.TempDir("", "bashful-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	scr.SetRenderer(newVirtualTerminal(80, 24))
+
+	path := filepath.Join(dir, "events.json")
+	if err := openEventStream(path); err != nil {
+		t.Fatal(err)
+	}
+	run([]byte(yamlStr), map[string]string{})
+	closeEventStream()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event runEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal("TestEventStream: Unable to parse event", repr.String(line), err)
+		}
+		if event.RunID != runID {
+			t.Error("TestEventStream: Expected every event to have the run id, got", repr.String(line))
+		}
+		description := event.Type + " " + event.Group + "/" + event.Task + " " + event.Stream + event.Line + event.Status
+		actual = append(actual, strings.TrimSpace(description))
+	}
+
+	expected := []string{
+		"run-started /",
+		"task-queued /greet",
+		"task-queued Group/fails",
+		"task-started /greet",
+		"task-output /greet stdouthello",
+		"task-completed /greet success",
+		"task-started Group/fails",
+		"task-output Group/fails stderrbroken",
+		"task-completed Group/fails failed",
+		"run-completed / failed",
+	}
+	if repr.String(expected) != repr.String(actual) {
+		t.Error("TestEventStream: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+}
+
+func TestUnixEventStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bashful-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "events.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if err := openEventStream("unix:" + socketPath); err != nil {
+		t.Fatal(err)
+	}
+	defer closeEventStream()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	publishEvent(runEvent{Type: "download-progress", URL: "https://example.com/script.sh", Bytes: 10, TotalBytes: 20})
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event runEvent
+	if err := json.Unmarshal([]byte(line), &event); err != nil || event.Type != "download-progress" || event.Bytes != 10 || event.TotalBytes != 20 {
+		t.Error("TestUnixEventStream: Expected a download progress event, got", repr.String(line))
+	}
+}
+
+func TestSlowEventStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bashful-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "events.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if err := openEventStream("unix:" + socketPath); err != nil {
+		t.Fatal(err)
+	}
+	defer closeEventStream()
+
+	// the consumer never reads a single event
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	done := make(chan bool)
+	go func() {
+		for idx := 0; idx < 20*eventStreamBuffer; idx++ {
+			publishEvent(runEvent{Type: "task-output", Task: "chatty", Stream: "stdout", Line: strings.Repeat("x", 100)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("TestSlowEventStream: Expected a slow consumer not to hold up publishing events")
+	}
+
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	if eventStream != nil {
+		t.Error("TestSlowEventStream: Expected the event stream to be closed once the consumer falls behind")
+	}
+}
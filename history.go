@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deckarep/golang-set"
+)
+
+// historyWritten indicates that the current run was already recorded to the run history (it is recorded only once)
+var historyWritten bool
+
+// historyResultName is the name of the result document within the history dir of a single run
+const historyResultName = "result.json"
+
+// historyPath is the dir path holding a dir for every past run (named by the run id)
+func historyPath() string {
+	return path.Join(cachePath(), "history")
+}
+
+// writeHistory records the current run (the result document and the output of every task) to the run history, removing
+// the oldest runs beyond the 'history-retention' option. Since this is called while exiting, any error is only reported.
+func writeHistory() {
+	if config.Options.HistoryRetention == 0 || runID == "" || len(allTasks) == 0 || historyWritten {
+		return
+	}
+	historyWritten = true
+
+	err := recordRun(path.Join(historyPath(), runID))
+	if err == nil {
+		err = pruneHistory(config.Options.HistoryRetention)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, red("Unable to record the run history: "+err.Error()))
+	}
+}
+
+// recordRun writes the result document of the current run to the given dir, along with a copy of the log of every task
+// that started (the log path of each task result is relative to the dir)
+func recordRun(dir string) error {
+	logDir := path.Join(dir, "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+
+	// the task results are in the same order as the tasks (a parallel task is described by its children)
+	result := newRunResult(allTasks, time.Now())
+	var tasks []*Task
+	for _, task := range allTasks {
+		if task.Config.CmdString != "" {
+			tasks = append(tasks, task)
+		}
+		tasks = append(tasks, task.Children...)
+	}
+
+	for idx, task := range tasks {
+		if !task.Command.Started {
+			result.Tasks[idx].LogPath = ""
+			continue
+		}
+		name := strings.Trim(unsafeLogNameChars.ReplaceAllString(taskKey(result.Tasks[idx].Group, task.Config.Name), "-"), "-.")
+		logPath := path.Join("logs", fmt.Sprintf("%03d-%s.log", idx+1, name))
+		if err := copyTaskLog(task, path.Join(dir, logPath)); err != nil {
+			return err
+		}
+		result.Tasks[idx].LogPath = logPath
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, historyResultName), append(data, '\n'), 0644)
+}
+
+// copyTaskLog writes the full log of the given (started) task to the given path. The log of a completed task is copied
+// once it has been completely written, after which a temporary log (one outside of the log-dir) is removed; a task
+// without a log file is described by its captured output.
+func copyTaskLog(task *Task, logPath string) error {
+	if task.LogFile == nil {
+		var output []string
+		for _, line := range task.CapturedOutput() {
+			output = append(output, stripEscapes(line)+"\n")
+		}
+		return ioutil.WriteFile(logPath, []byte(strings.Join(output, "")), 0644)
+	}
+
+	written := awaitTaskLog(task)
+	data, err := ioutil.ReadFile(task.LogFile.Name())
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(logPath, data, 0644); err != nil {
+		return err
+	}
+	if written && config.Options.LogDir == "" {
+		os.Remove(task.LogFile.Name())
+	}
+	return nil
+}
+
+// readHistory returns the result document of every past run in the run history (oldest first)
+func readHistory() ([]runResult, error) {
+	entries, err := ioutil.ReadDir(historyPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var results []runResult
+	for _, entry := range entries {
+		data, err := ioutil.ReadFile(path.Join(historyPath(), entry.Name(), historyResultName))
+		if err != nil {
+			continue
+		}
+		var result runResult
+		if json.Unmarshal(data, &result) == nil && result.RunID == entry.Name() {
+			results = append(results, result)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].StartTime.Before(results[j].StartTime)
+	})
+	return results, nil
+}
+
+// pruneHistory removes the oldest runs from the run history until only the given number of runs remain
+func pruneHistory(retention int) error {
+	results, err := readHistory()
+	if err != nil {
+		return err
+	}
+	for idx := 0; idx < len(results)-retention; idx++ {
+		if err := os.RemoveAll(path.Join(historyPath(), results[idx].RunID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findHistoryRun returns the past run with the given id ('last' is the most recent run)
+func findHistoryRun(id string) (runResult, error) {
+	results, err := readHistory()
+	if err != nil {
+		return runResult{}, err
+	}
+	if len(results) == 0 {
+		return runResult{}, errors.New("no runs recorded in " + historyPath())
+	}
+	if id == "last" {
+		return results[len(results)-1], nil
+	}
+	for _, result := range results {
+		if result.RunID == id {
+			return result, nil
+		}
+	}
+	return runResult{}, errors.New("no run with the id '" + id + "' (see 'bashful history')")
+}
+
+// rerunTasks returns the identities (see TaskConfig.identify) of every task that did not succeed in the most recent run of the given yaml
+// file (tasks skipped by tags are not run again). The set is empty when every task succeeded.
+func rerunTasks(yamlPath string) (mapset.Set, error) {
+	results, err := readHistory()
+	if err != nil {
+		return nil, err
+	}
+	for idx := len(results) - 1; idx >= 0; idx-- {
+		if results[idx].Yaml != yamlPath {
+			continue
+		}
+		keys := mapset.NewSet()
+		for _, task := range results[idx].Tasks {
+			if task.Status != "success" && task.Status != "skipped" {
+				keys.Add(task.ID)
+			}
+		}
+		return keys, nil
+	}
+	return nil, errors.New("no previous run of " + yamlPath + " in " + historyPath())
+}
+
+// historyListing describes every past run in the run history, one line per run (oldest first)
+func historyListing(results []runResult) string {
+	lines := []string{fmt.Sprintf("%-26s %-12s %-9s %-20s %s", "RUN ID", "STATUS", "DURATION", "STARTED", "TASKS")}
+	for _, result := range results {
+		failed := 0
+		for _, task := range result.Tasks {
+			if task.Status == "failed" || task.Status == "cancelled" {
+				failed++
+			}
+		}
+		tasks := strconv.Itoa(len(result.Tasks)) + " tasks"
+		if failed > 0 {
+			tasks += ", " + strconv.Itoa(failed) + " failed"
+		}
+		if result.Yaml != "" {
+			tasks += " (" + result.Yaml + ")"
+		}
+		duration := showDuration(time.Duration(result.Duration * float64(time.Second)))
+		lines = append(lines, fmt.Sprintf("%-26s %-12s %-9s %-20s %s", result.RunID, result.Status, duration, result.StartTime.Local().Format("2006-01-02 15:04:05"), tasks))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// historyRunSummary describes a single past run and the status of every task within it
+func historyRunSummary(result runResult) string {
+	lines := []string{fmt.Sprintf("Run %s: %s (started %s, took %s)", result.RunID, result.Status, result.StartTime.Local().Format("2006-01-02 15:04:05"), showDuration(time.Duration(result.Duration*float64(time.Second))))}
+	if result.Yaml != "" {
+		lines = append(lines, "Yaml: "+result.Yaml)
+	}
+	for _, task := range result.Tasks {
+		duration := ""
+		if task.StartTime != nil && task.StopTime != nil {
+			duration = showDuration(task.StopTime.Sub(*task.StartTime))
+		}
+		name := task.Name
+		if task.Group != "" {
+			name = task.Group + "/" + task.Name
+		}
+		lines = append(lines, fmt.Sprintf("  %-10s %8s  %s", task.Status, duration, name))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// historyTaskOutput returns the recorded output of the task with the given name (or 'group/name') within the given past run
+func historyTaskOutput(result runResult, name string) (string, error) {
+	var matches []taskResult
+	for _, task := range result.Tasks {
+		if task.Name == name || taskKey(task.Group, task.Name) == name {
+			matches = append(matches, task)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return "", errors.New("no task named '" + name + "' in run " + result.RunID)
+	case len(matches) > 1:
+		return "", errors.New("several tasks are named '" + name + "' in run " + result.RunID + " (give it as 'group/name')")
+	case matches[0].LogPath == "":
+		return "", errors.New("the task '" + name + "' did not run in run " + result.RunID)
+	}
+
+	logPath := matches[0].LogPath
+	if !path.IsAbs(logPath) {
+		logPath = path.Join(historyPath(), result.RunID, logPath)
+	}
+	data, err := ioutil.ReadFile(logPath)
+	return string(data), err
+}
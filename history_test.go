@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestRunHistory(t *testing.T) {
+	yamlStr := `
+config:
+  history-retention: 2
+  stop-on-failure: false
+tasks:
+  - name: setup
+    cmd: echo ready
+  - name: Group
+    parallel-tasks:
+      - name: fails
+        cmd: echo broken; exit 3
+      - name: passes
+        cmd: "true"
+      - name: check
+        cmd: test <replace> != b
+        for-each: [a, b]
+`
+	dir, err := ioutil.TempDir("", "bashful-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cachePathValue := config.CachePath
+	defer func() {
+		config.CachePath = cachePathValue
+		config.Cli = CliOptions{}
+		config.prunedTasks = nil
+	}()
+	config.CachePath = dir
+	config.Cli.YamlPath = "/path/to/run.yml"
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	scr.SetRenderer(newVirtualTerminal(80, 24))
+
+	run([]byte(yamlStr), map[string]string{})
+
+	result, err := findHistoryRun("last")
+	if err != nil || result.RunID != runID || result.Status != "failed" || result.Yaml != "/path/to/run.yml" {
+		t.Fatal("TestRunHistory: Expected the run to be recorded, got", repr.String(result), err)
+	}
+	for _, name := range []string{"fails", "Group/fails"} {
+		if output, err := historyTaskOutput(result, name); !strings.Contains(output, "broken") || err != nil {
+			t.Error("TestRunHistory: Expected the output of", name, "to be recorded, got", repr.String(output), err)
+		}
+	}
+	for _, task := range result.Tasks {
+		if task.LogPath != "" && filepath.IsAbs(task.LogPath) {
+			t.Error("TestRunHistory: Expected the log path to be relative to the run dir, got", task.LogPath)
+		}
+	}
+	for _, task := range allTasks[1].Children {
+		if _, err := os.Stat(task.LogFile.Name()); !os.IsNotExist(err) {
+			t.Error("TestRunHistory: Expected the temporary log to be removed once recorded, got", task.LogFile.Name(), err)
+		}
+	}
+	if _, err := historyTaskOutput(result, "missing"); err == nil {
+		t.Error("TestRunHistory: Expected an error for a task that does not exist")
+	}
+
+	// only the failed tasks run again (all others are skipped, including a replica with the same name)
+	config.Cli.RerunTasks, err = rerunTasks("/path/to/run.yml")
+	var rerun []string
+	if err == nil {
+		for _, key := range config.Cli.RerunTasks.ToSlice() {
+			rerun = append(rerun, key.(string))
+		}
+		sort.Strings(rerun)
+	}
+	if expected := []string{"/path/to/run.yml:Group/check[b]", "/path/to/run.yml:Group/fails"}; repr.String(rerun) != repr.String(expected) {
+		t.Fatal("TestRunHistory: Expected only the failed tasks to rerun, got", repr.String(rerun), err)
+	}
+	run([]byte(yamlStr), map[string]string{})
+
+	if len(allTasks) != 1 || len(allTasks[0].Children) != 2 || allTasks[0].Children[0].Config.Name != "fails" || allTasks[0].Children[1].Config.CmdString != "test b != b" {
+		t.Error("TestRunHistory: Expected only the failed tasks to run, got", len(allTasks), "tasks")
+	}
+	var pruned []string
+	for _, task := range config.prunedTasks {
+		pruned = append(pruned, taskKey(task.Group, task.Config.Name)+": "+task.Reason)
+	}
+	expected := []string{"/setup: " + prunedByRerun, "Group/passes: " + prunedByRerun, "Group/check: " + prunedByRerun}
+	if repr.String(pruned) != repr.String(expected) {
+		t.Error("TestRunHistory: Expected", repr.String(expected), "got", repr.String(pruned))
+	}
+
+	// only the newest runs are kept
+	config.Cli.RerunTasks = nil
+	run([]byte(yamlStr), map[string]string{})
+	results, err := readHistory()
+	if err != nil || len(results) != 2 || results[1].RunID != runID {
+		t.Error("TestRunHistory: Expected the two most recent runs to be kept, got", repr.String(results), err)
+	}
+	if _, err := rerunTasks("/path/to/other.yml"); err == nil {
+		t.Error("TestRunHistory: Expected an error rerunning a yaml file that never ran")
+	}
+}
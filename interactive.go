@@ -0,0 +1,378 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var (
+	// interactive is the state of the interactive mode (keyboard navigation over the task lines of the current frame)
+	interactive interactiveState
+
+	// keyPresses receives every key pressed by the user (only while the interactive mode is enabled)
+	keyPresses chan string
+
+	// interactiveHelp is the list of key bindings shown on the help overlay
+	interactiveHelp = []string{
+		"  ↑/k  ↓/j    select the previous/next task",
+		"  enter/o     page through the output of the selected task",
+		"  c           cancel the selected task (all other tasks keep running)",
+		"  p           pause/resume starting new tasks",
+		"  ?           show/hide this help",
+		"  ctrl+c      abort the entire run",
+	}
+)
+
+// interactiveState tracks the selected task and any overlay (help or task output) shown over the screen frame
+type interactiveState struct {
+	// enabled indicates that keyboard input is being read from the terminal
+	enabled bool
+
+	// focus is the selected task within the current screen frame (nil indicates no selection)
+	focus *Task
+
+	// paused indicates that no new task commands should be started
+	paused bool
+
+	// inspecting is the task whose captured output is shown on the output overlay (nil indicates the overlay is hidden)
+	inspecting *Task
+
+	// offset is the first captured output line shown on the output overlay
+	offset int
+
+	// following indicates that the output overlay should keep showing the newest captured output lines
+	following bool
+
+	// showHelp indicates that the help overlay is shown
+	showHelp bool
+
+	// terminalState is the original stty configuration to restore when the interactive mode is stopped
+	terminalState string
+}
+
+// startInteractive configures the terminal to send each key press immediately (without echo) and starts reading key presses
+func startInteractive() {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		exitWithErrorMessage("Interactive mode requires a terminal")
+	}
+	if config.Options.SingleLineDisplay {
+		exitWithErrorMessage("Interactive mode cannot be used with the 'single-line' option")
+	}
+
+	state, err := stty("-g")
+	checkError(err, "Unable to read terminal settings")
+	interactive.terminalState = strings.TrimSpace(state)
+
+	_, err = stty("-icanon", "-echo", "min", "1")
+	checkError(err, "Unable to configure terminal for interactive mode")
+
+	interactive.enabled = true
+	keyPresses = make(chan string, 10)
+	go readKeys(os.Stdin, keyPresses)
+}
+
+// stopInteractive closes any overlay and restores the original terminal settings
+func stopInteractive() {
+	if !interactive.enabled {
+		return
+	}
+	interactive.closeOverlay()
+	interactive.enabled = false
+	stty(interactive.terminalState)
+}
+
+// stty runs stty with the given arguments against the terminal attached to stdin
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// readKeys decodes all key presses from the given reader onto the given channel
+func readKeys(reader io.Reader, keys chan string) {
+	buffer := make([]byte, 64)
+	for {
+		count, err := reader.Read(buffer)
+		if err != nil {
+			return
+		}
+		for _, key := range decodeKeys(buffer[:count]) {
+			keys <- key
+		}
+	}
+}
+
+// decodeKeys converts raw terminal input into key names ("up", "down", "pgup", "pgdn", "enter", "esc", or the character itself)
+func decodeKeys(data []byte) (keys []string) {
+	sequences := map[string]string{
+		"\x1b[A":  "up",
+		"\x1b[B":  "down",
+		"\x1bOA":  "up",
+		"\x1bOB":  "down",
+		"\x1b[5~": "pgup",
+		"\x1b[6~": "pgdn",
+		"\x1b[H":  "home",
+		"\x1b[F":  "end",
+	}
+
+	input := string(data)
+	for len(input) > 0 {
+		matched := false
+		for sequence, key := range sequences {
+			if strings.HasPrefix(input, sequence) {
+				keys = append(keys, key)
+				input = input[len(sequence):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		switch input[0] {
+		case '\x1b':
+			keys = append(keys, "esc")
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case ' ':
+			keys = append(keys, "space")
+		default:
+			keys = append(keys, string(input[0]))
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// frameTasks returns all tasks shown within the screen frame of the given task (in display order)
+func frameTasks(group *Task) (tasks []*Task) {
+	if group.Config.CmdString != "" {
+		tasks = append(tasks, group)
+	}
+	return append(tasks, group.Children...)
+}
+
+// moveFocus selects the task that is the given number of rows away from the current selection
+func (state *interactiveState) moveFocus(group *Task, delta int) {
+	tasks := frameTasks(group)
+	if len(tasks) == 0 {
+		return
+	}
+
+	index := -1
+	for idx, task := range tasks {
+		if task == state.focus {
+			index = idx
+		}
+	}
+
+	if index == -1 {
+		index = 0
+	} else {
+		index += delta
+	}
+	if index < 0 {
+		index = 0
+	}
+	if index > len(tasks)-1 {
+		index = len(tasks) - 1
+	}
+	state.focus = tasks[index]
+}
+
+// markFocus highlights the given task line if the task is selected
+func (task *Task) markFocus(line string) string {
+	if interactive.focus != task || !strings.HasPrefix(line, " ") {
+		return line
+	}
+	return bold(">") + line[1:]
+}
+
+// footerMessage is shown on the summary footer to indicate the interactive mode state
+func (state *interactiveState) footerMessage() string {
+	if state.paused && state.enabled {
+		return purple(" Paused (press p to resume)")
+	}
+	if state.paused {
+		return purple(" Paused (run 'bashful ctl resume' to resume)")
+	}
+	if state.enabled {
+		return purple(" Press ? for help")
+	}
+	return ""
+}
+
+// overlayShown indicates if the screen frame is hidden behind an overlay
+func (state *interactiveState) overlayShown() bool {
+	return state.showHelp || state.inspecting != nil
+}
+
+// openOverlay switches to the alternate screen (preserving the screen frame) and shows the help or task output overlay
+func (state *interactiveState) openOverlay(inspect *Task, showHelp bool) {
+	if !state.overlayShown() {
+		newScreen().Print("\x1b[?1049h")
+	}
+	state.inspecting = inspect
+	state.showHelp = showHelp
+	state.following = true
+	state.refreshOverlay()
+}
+
+// closeOverlay switches back from the alternate screen (restoring the screen frame)
+func (state *interactiveState) closeOverlay() {
+	if !state.overlayShown() {
+		return
+	}
+	state.inspecting = nil
+	state.showHelp = false
+	newScreen().Print("\x1b[?1049l")
+}
+
+// overlaySize returns the number of columns and rows available to an overlay
+func overlaySize() (int, int) {
+	scr := newScreen()
+	height := scr.renderer.Height()
+	if height <= 0 {
+		height = 24
+	}
+	return scr.renderer.Width(), height
+}
+
+// refreshOverlay redraws the entire overlay that is currently shown
+func (state *interactiveState) refreshOverlay() {
+	var lines []string
+	width, height := overlaySize()
+
+	if state.showHelp {
+		lines = append([]string{bold(" bashful interactive mode"), ""}, interactiveHelp...)
+	} else if state.inspecting != nil {
+		output := state.inspecting.CapturedOutput()
+		pageSize := height - 2
+
+		if state.following || state.offset > len(output)-pageSize {
+			state.offset = len(output) - pageSize
+		}
+		if state.offset < 0 {
+			state.offset = 0
+		}
+		last := state.offset + pageSize
+		if last > len(output) {
+			last = len(output)
+		}
+
+		title := " Output of " + state.inspecting.Config.Name + " (lines " + strconv.Itoa(state.offset+1) + "-" + strconv.Itoa(last) + " of " + strconv.Itoa(len(output)) + ")"
+		if len(output) == 0 {
+			title = " Output of " + state.inspecting.Config.Name + " (no output yet)"
+		}
+		lines = append(lines, bold(title))
+		lines = append(lines, output[state.offset:last]...)
+		for len(lines) < height-1 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, purple(" ↑/↓ scroll  PgUp/PgDn page  g/G top/bottom  q back"))
+	}
+
+	var buffer strings.Builder
+	buffer.WriteString("\x1b[H\x1b[2J")
+	for idx, line := range lines {
+		if idx >= height {
+			break
+		}
+		if visualLength(line) > width {
+			line = trimToVisualLength(line, width)
+		}
+		buffer.WriteString(line + resetColor())
+		if idx < len(lines)-1 && idx < height-1 {
+			buffer.WriteString("\r\n")
+		}
+	}
+	newScreen().Print(buffer.String())
+}
+
+// scrollOverlay moves the output overlay by the given number of lines
+func (state *interactiveState) scrollOverlay(delta int) {
+	_, height := overlaySize()
+	state.offset += delta
+	if state.offset < 0 {
+		state.offset = 0
+	}
+	// follow new output once scrolled to the bottom
+	state.following = state.offset >= len(state.inspecting.CapturedOutput())-(height-2)
+	state.refreshOverlay()
+}
+
+// handleKey acts on a single key press from the user
+func (task *Task) handleKey(key string, environment map[string]string) {
+	_, height := overlaySize()
+
+	switch {
+	case interactive.showHelp:
+		interactive.closeOverlay()
+
+	case interactive.inspecting != nil:
+		switch key {
+		case "q", "esc", "enter":
+			interactive.closeOverlay()
+		case "up", "k":
+			interactive.scrollOverlay(-1)
+		case "down", "j":
+			interactive.scrollOverlay(1)
+		case "pgup", "b":
+			interactive.scrollOverlay(-(height - 2))
+		case "pgdn", "space":
+			interactive.scrollOverlay(height - 2)
+		case "g", "home":
+			interactive.scrollOverlay(-len(interactive.inspecting.CapturedOutput()))
+		case "G", "end":
+			interactive.scrollOverlay(len(interactive.inspecting.CapturedOutput()))
+		}
+
+	default:
+		switch key {
+		case "up", "k":
+			interactive.moveFocus(task, -1)
+		case "down", "j":
+			interactive.moveFocus(task, 1)
+		case "enter", "space", "o":
+			if interactive.focus == nil {
+				interactive.moveFocus(task, 0)
+			}
+			if interactive.focus != nil {
+				interactive.openOverlay(interactive.focus, false)
+			}
+		case "c", "x":
+			if interactive.focus != nil {
+				interactive.focus.Cancel()
+			}
+		case "p":
+			interactive.paused = !interactive.paused
+			if !interactive.paused {
+				task.StartAvailableTasks(environment)
+			}
+		case "?", "h":
+			interactive.openOverlay(nil, true)
+		}
+	}
+
+	// redraw the screen frame to reflect the new selection or state
+	if !interactive.overlayShown() {
+		task.redrawFrame()
+	}
+}
+
+// redrawFrame brings the screen frame and summary footer up to date outside of a regular frame update
+func (task *Task) redrawFrame() {
+	scr := newScreen()
+	task.relayout()
+	task.displayFrame()
+	if config.Options.ShowSummaryFooter {
+		scr.DisplayFooter(footer(statusPending, interactive.footerMessage()))
+	} else {
+		scr.MovePastFrame(false)
+	}
+}
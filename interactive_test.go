@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestDecodeKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"j", []string{"j"}},
+		{"\x1b[A\x1b[B", []string{"up", "down"}},
+		{"\x1bOA", []string{"up"}},
+		{"\x1b[5~\x1b[6~", []string{"pgup", "pgdn"}},
+		{"\r \x1b", []string{"enter", "space", "esc"}},
+		{"c\x1b[Bp", []string{"c", "down", "p"}},
+	}
+
+	for _, test := range tests {
+		actual := decodeKeys([]byte(test.input))
+		if repr.String(test.expected) != repr.String(actual) {
+			t.Error("TestDecodeKeys: Expected", repr.String(test.expected), "got", repr.String(actual), "for", repr.String(test.input))
+		}
+	}
+}
+
+func TestMoveFocus(t *testing.T) {
+	defer func() { interactive = interactiveState{} }()
+
+	group := NewTask(TaskConfig{Name: "group"}, 1, "1")
+	for _, name := range []string{"a", "b", "c"} {
+		group.Children = append(group.Children, NewTask(TaskConfig{Name: name, CmdString: "true"}, 1, "1"))
+	}
+
+	steps := []struct {
+		delta    int
+		expected string
+	}{
+		{1, "a"}, // nothing selected yet: select the first task
+		{1, "b"},
+		{1, "c"},
+		{1, "c"}, // stay on the last task
+		{-2, "a"},
+		{-1, "a"}, // stay on the first task
+	}
+
+	interactive.focus = nil
+	for idx, step := range steps {
+		interactive.moveFocus(group, step.delta)
+		if interactive.focus == nil || interactive.focus.Config.Name != step.expected {
+			t.Error("TestMoveFocus: Expected", repr.String(step.expected), "at step", idx)
+		}
+	}
+
+	line := " some line"
+	if group.Children[0].markFocus(line) != bold(">")+"some line" {
+		t.Error("TestMoveFocus: Expected the selected task line to be marked, got", repr.String(group.Children[0].markFocus(line)))
+	}
+	if group.Children[1].markFocus(line) != line {
+		t.Error("TestMoveFocus: Expected an unselected task line to be unchanged, got", repr.String(group.Children[1].markFocus(line)))
+	}
+}
+
+func TestPausedStartAvailableTasks(t *testing.T) {
+	defer func() { interactive = interactiveState{} }()
+
+	config.Options.MaxParallelCmds = 4
+	TaskStats.runningCmds = 0
+
+	group := NewTask(TaskConfig{Name: "group"}, 1, "1")
+	group.Children = append(group.Children, NewTask(TaskConfig{Name: "a", CmdString: "true"}, 1, "1"))
+
+	interactive.paused = true
+	group.StartAvailableTasks(nil)
+	if group.Children[0].Command.Started || TaskStats.runningCmds != 0 {
+		t.Error("TestPausedStartAvailableTasks: Expected no tasks to be started while paused")
+	}
+	if !group.hasUnstartedTasks() {
+		t.Error("TestPausedStartAvailableTasks: Expected unstarted tasks to remain while paused")
+	}
+}
+
+func TestOutputOverlay(t *testing.T) {
+	yamlStr := `
+config:
+  show-summary-times: false
+tasks:
+  - name: Inspected
+    parallel-tasks:
+      - name: first
+        cmd: echo first
+      - name: second
+        cmd: echo second
+`
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	defer func() { interactive = interactiveState{} }()
+
+	term := newVirtualTerminal(60, 6)
+	scr.SetRenderer(term)
+	TaskStats.completedTasks, TaskStats.totalTasks = 0, 0
+	parseRunYaml([]byte(yamlStr))
+	tasks := CreateTasks()
+	tasks[0].Pave()
+	frame := term.String()
+
+	for idx := 1; idx <= 8; idx++ {
+		tasks[0].Children[1].captureOutput("line " + strings.Repeat("#", idx))
+	}
+
+	// select the second task and page through its output (following the newest lines)
+	for _, key := range []string{"down", "down", "enter"} {
+		tasks[0].handleKey(key, nil)
+	}
+	assertGoldenScreen(t, "output-overlay", term)
+
+	// scrolling to the top shows the oldest lines
+	tasks[0].handleKey("g", nil)
+	if !strings.Contains(term.String(), "(lines 1-4 of 8)") {
+		t.Error("TestOutputOverlay: Expected the first page of output, got", repr.String(term.String()))
+	}
+
+	// closing the overlay restores the screen frame
+	tasks[0].handleKey("q", nil)
+	if interactive.overlayShown() {
+		t.Error("TestOutputOverlay: Expected the overlay to be closed")
+	}
+	if !strings.Contains(term.String(), "second") || !strings.HasPrefix(strings.Split(term.String(), "\n")[0], strings.Split(frame, "\n")[0]) {
+		t.Error("TestOutputOverlay: Expected the screen frame to be restored, got", repr.String(term.String()))
+	}
+}
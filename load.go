@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadSampleInterval is the shortest time between two reads of the system load (see throttleReason)
+const loadSampleInterval = time.Second
+
+var (
+	// procPath is the dir the system load is read from (the load average and memory usage)
+	procPath = "/proc"
+
+	// cpuExpression matches a 'max-parallel-commands' value given in terms of the number of cpus (e.g. 'cpus-1')
+	cpuExpression = regexp.MustCompile(`^cpus\s*(?:([-+*/])\s*(\d+))?$`)
+
+	// systemLoad is the latest sample of the system load, which holds back new commands while the system is busy
+	systemLoad struct {
+		// sampled is when the system load was last read
+		sampled time.Time
+
+		// reason describes why new commands are held back (empty when they are not)
+		reason string
+	}
+)
+
+// parseParallelLimit returns the number of commands to run in parallel given as a number, 'auto' (the number of cpus),
+// or an expression of the number of cpus (e.g. 'cpus-1', 'cpus+2', 'cpus*2', or 'cpus/2'). An expression always allows
+// at least one command (e.g. 'cpus-1' on a single cpu).
+func parseParallelLimit(value string, cpus int) (int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	invalid := errors.New("'" + value + "' is not a number greater than zero, 'auto', or an expression of 'cpus' (e.g. 'cpus-1' or 'cpus*2')")
+
+	if value == "auto" {
+		return cpus, nil
+	}
+	if count, err := strconv.Atoi(value); err == nil {
+		if count < 1 {
+			return 0, invalid
+		}
+		return count, nil
+	}
+
+	match := cpuExpression.FindStringSubmatch(value)
+	if match == nil {
+		return 0, invalid
+	}
+	count := cpus
+	operand, _ := strconv.Atoi(match[2])
+	switch match[1] {
+	case "-":
+		count -= operand
+	case "+":
+		count += operand
+	case "*":
+		count *= operand
+	case "/":
+		if operand == 0 {
+			return 0, invalid
+		}
+		count /= operand
+	}
+	if count < 1 {
+		count = 1
+	}
+	return count, nil
+}
+
+// readLoadAverage returns the 1-minute load average of the system (from the loadavg file of the given proc dir)
+func readLoadAverage(procDir string) (float64, error) {
+	data, err := ioutil.ReadFile(path.Join(procDir, "loadavg"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, errors.New("no load average in " + path.Join(procDir, "loadavg"))
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// readMemoryUsage returns the percent of memory in use: all memory except what is available to start new processes
+// without swapping (from the meminfo file of the given proc dir)
+func readMemoryUsage(procDir string) (float64, error) {
+	file, err := os.Open(path.Join(procDir, "meminfo"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	values := map[string]float64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 {
+			if value, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				values[strings.TrimSuffix(fields[0], ":")] = value
+			}
+		}
+	}
+	// no memory may be available at all (only a missing value means there is no usage to report)
+	total, totalOk := values["MemTotal"]
+	available, availableOk := values["MemAvailable"]
+	if !totalOk || !availableOk || total <= 0 {
+		return 0, errors.New("no memory usage in " + path.Join(procDir, "meminfo"))
+	}
+	return (total - available) * 100 / total, nil
+}
+
+// loadThrottleReason describes why new commands should be held back given the 'throttle-load' and 'throttle-memory'
+// options (empty when they should not). A value that cannot be read (e.g. without a proc dir) holds back nothing.
+func loadThrottleReason(procDir string, cpus int) string {
+	if limit := config.Options.ThrottleLoad * float64(cpus); limit > 0 {
+		if load, err := readLoadAverage(procDir); err == nil && load > limit {
+			return fmt.Sprintf("load %.2f above %.2f", load, limit)
+		}
+	}
+	if limit := config.Options.ThrottleMemory; limit > 0 {
+		if used, err := readMemoryUsage(procDir); err == nil && used > limit {
+			return fmt.Sprintf("memory %.0f%% used", used)
+		}
+	}
+	return ""
+}
+
+// throttleReason describes why new commands are held back while the system is busy (empty when they are not). The
+// system load is read at most once every loadSampleInterval.
+func throttleReason() string {
+	if config.Options.ThrottleLoad <= 0 && config.Options.ThrottleMemory <= 0 {
+		return ""
+	}
+	if time.Since(systemLoad.sampled) >= loadSampleInterval {
+		systemLoad.sampled = time.Now()
+		systemLoad.reason = loadThrottleReason(procPath, runtime.NumCPU())
+	}
+	return systemLoad.reason
+}
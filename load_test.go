@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseParallelLimit(t *testing.T) {
+	tests := map[string]int{
+		"6":        6,
+		"auto":     8,
+		"cpus":     8,
+		"cpus-1":   7,
+		"cpus - 1": 7,
+		"CPUS*2":   16,
+		"cpus+2":   10,
+		"cpus/3":   2,
+		"cpus-12":  1,
+	}
+	for value, expected := range tests {
+		if actual, err := parseParallelLimit(value, 8); actual != expected || err != nil {
+			t.Error("TestParseParallelLimit: Expected", value, "to be", expected, "got", actual, err)
+		}
+	}
+	for _, value := range []string{"0", "-2", "cpus/0", "cores", "cpus%2", ""} {
+		if _, err := parseParallelLimit(value, 8); err == nil {
+			t.Error("TestParseParallelLimit: Expected an error for", value)
+		}
+	}
+
+	parseRunYaml([]byte("config:\n  max-parallel-commands: cpus*2\ntasks:\n  - cmd: echo\n"))
+	if config.Options.MaxParallelCmds != runtime.NumCPU()*2 {
+		t.Error("TestParseParallelLimit: Expected the option to be resolved, got", config.Options.MaxParallelCmds)
+	}
+	parseRunYaml([]byte("config:\n  max-parallel-commands: 3\ntasks:\n  - cmd: echo\n"))
+	if config.Options.MaxParallelCmds != 3 {
+		t.Error("TestParseParallelLimit: Expected a number to be kept, got", config.Options.MaxParallelCmds)
+	}
+}
+
+func TestLoadThrottle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bashful-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	optionsValue := config.Options
+	defer func() { config.Options = optionsValue }()
+	config.Options = NewOptionsConfig()
+
+	if reason := loadThrottleReason(dir, 4); reason != "" {
+		t.Error("TestLoadThrottle: Expected no throttle without any options, got", reason)
+	}
+
+	// without a proc dir nothing is held back
+	config.Options.ThrottleLoad, config.Options.ThrottleMemory = 1.5, 90
+	if reason := loadThrottleReason(dir, 4); reason != "" {
+		t.Error("TestLoadThrottle: Expected no throttle when the load cannot be read, got", reason)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "loadavg"), []byte("7.50 6.00 5.00 3/512 12345\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "meminfo"), []byte("MemTotal:       16000000 kB\nMemFree:          500000 kB\nMemAvailable:    1000000 kB\n"), 0644)
+	tests := []struct {
+		cpus     int
+		expected string
+	}{
+		{4, "load 7.50 above 6.00"},
+		{8, "memory 94% used"},
+	}
+	for _, test := range tests {
+		if reason := loadThrottleReason(dir, test.cpus); reason != test.expected {
+			t.Error("TestLoadThrottle: Expected", test.expected, "got", reason)
+		}
+	}
+
+	config.Options.ThrottleMemory = 95
+	if reason := loadThrottleReason(dir, 8); reason != "" {
+		t.Error("TestLoadThrottle: Expected no throttle below the limits, got", reason)
+	}
+
+	// without any available memory the system is fully used (not missing a value)
+	ioutil.WriteFile(filepath.Join(dir, "meminfo"), []byte("MemTotal:       16000000 kB\nMemFree:           10000 kB\nMemAvailable:          0 kB\n"), 0644)
+	if reason := loadThrottleReason(dir, 8); reason != "memory 100% used" {
+		t.Error("TestLoadThrottle: Expected no available memory to throttle, got", reason)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "meminfo"), []byte("MemTotal:       16000000 kB\nMemFree:           10000 kB\n"), 0644)
+	if _, err := readMemoryUsage(dir); err == nil {
+		t.Error("TestLoadThrottle: Expected an error without the available memory")
+	}
+}
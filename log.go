@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	color "github.com/mgutz/ansi"
 )
@@ -13,6 +21,18 @@ import (
 var (
 	mainLogChan       = make(chan LogItem)
 	mainLogConcatChan = make(chan LogConcat)
+
+	// logNameLock guards logNames, as task logs are created from concurrently running tasks
+	logNameLock sync.Mutex
+
+	// logNames is the set of task log filenames already claimed within the log-dir during this run
+	logNames map[string]bool
+
+	// taskLogTimeout is the longest time to wait on the log of a completed task to be written
+	taskLogTimeout = 5 * time.Second
+
+	// unsafeLogNameChars matches all characters that should not be used within a task log filename
+	unsafeLogNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
 )
 
 // LogItem represents all fields in a log message
@@ -23,7 +43,22 @@ type LogItem struct {
 
 // LogConcat contains all metadata necessary to concatenate a subprocess log to the main log
 type LogConcat struct {
+	// File is the path to the subprocess log
 	File string
+
+	// Keep indicates that the subprocess log should be retained after concatenation (instead of being removed)
+	Keep bool
+
+	// Done is closed once the subprocess log was concatenated (or could not be)
+	Done chan bool
+}
+
+// logColors applies the 'log-colors' option to the given log message (removing all ansi values when set to 'strip')
+func logColors(message string) string {
+	if config.Options.LogColors == "strip" {
+		return stripEscapes(message)
+	}
+	return message
 }
 
 func logToMain(msg, format string) {
@@ -66,30 +101,195 @@ func setupLogging() {
 		exitWithErrorMessage("\nUnable to create log dir\n" + err.Error())
 	}
 
+	if config.Options.LogDir != "" {
+		err = os.MkdirAll(config.Options.LogDir, 0755)
+		if err != nil {
+			exitWithErrorMessage("\nUnable to create task log dir\n" + err.Error())
+		}
+		logNames = make(map[string]bool)
+	}
+
 	removeDirContents(config.logCachePath)
-	go mainLogger(config.Options.LogPath)
+	if config.Options.LogPath != "" {
+		go mainLogger(config.Options.LogPath)
+	}
+}
+
+// awaitTaskLog waits until every entry of the log of the given completed task has been written (giving up after
+// taskLogTimeout), returning whether the log is complete
+func awaitTaskLog(task *Task) bool {
+	if !task.Command.Complete || task.logWritten == nil {
+		return false
+	}
+	select {
+	case <-task.logWritten:
+		return true
+	case <-time.After(taskLogTimeout):
+		return false
+	}
+}
+
+// logFileName derives a unique, filesystem-safe log filename from the given task name
+func logFileName(name string) string {
+	base := strings.Trim(unsafeLogNameChars.ReplaceAllString(name, "-"), "-.")
+	if len(base) > 100 {
+		base = base[:100]
+	}
+	if base == "" {
+		base = "task"
+	}
+
+	logNameLock.Lock()
+	defer logNameLock.Unlock()
+
+	filename := base + ".log"
+	for idx := 2; logNames[filename]; idx++ {
+		filename = base + "-" + strconv.Itoa(idx) + ".log"
+	}
+	logNames[filename] = true
+	return filename
+}
+
+// newTaskLogFile creates the file that will hold the full output of the given task. This is a file named after the task
+// within the log-dir (if configured) or a temporary file that is removed after being concatenated with the main log.
+func newTaskLogFile(task *Task) *os.File {
+	if config.Options.LogDir == "" {
+		tempFile, err := ioutil.TempFile(config.logCachePath, "")
+		checkError(err, "Unable to create temporary log")
+		return tempFile
+	}
+
+	file, err := os.Create(filepath.Join(config.Options.LogDir, logFileName(task.Config.Name)))
+	checkError(err, "Unable to create task log")
+	return file
+}
+
+// taskLogHeader describes the command that is about to be executed (written at the top of a task log)
+func taskLogHeader(task *Task) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Command:  " + task.Config.CmdString + "\n")
+	buffer.WriteString("Started:  " + task.Command.StartTime.Format(time.RFC3339) + "\n")
+	buffer.WriteString(strings.Repeat("─", 40) + "\n")
+	return buffer.String()
+}
+
+// taskLogFooter describes the outcome of the command that was executed (written at the bottom of a task log)
+func taskLogFooter(task *Task, returnCode int, envDelta []string) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(strings.Repeat("─", 40) + "\n")
+	buffer.WriteString("Stopped:  " + task.Command.StopTime.Format(time.RFC3339) + "\n")
+	buffer.WriteString("Duration: " + showDuration(task.Command.StopTime.Sub(task.Command.StartTime)) + "\n")
+	buffer.WriteString("Return code: " + strconv.Itoa(returnCode) + "\n")
+	buffer.WriteString("Environment delta:")
+	if len(envDelta) == 0 {
+		buffer.WriteString(" (none)")
+	}
+	buffer.WriteString("\n")
+	for _, line := range envDelta {
+		buffer.WriteString("  " + line + "\n")
+	}
+	return buffer.String()
+}
+
+// environmentDelta lists all env vars that were added (+), changed (~), or removed (-) between the given environments
+func environmentDelta(before, after map[string]string) (delta []string) {
+	for key, value := range after {
+		// these are maintained by the child shell itself and change on every invocation
+		if key == "_" || key == "SHLVL" {
+			continue
+		}
+		if previous, ok := before[key]; !ok {
+			delta = append(delta, "+ "+key+"="+value)
+		} else if previous != value {
+			delta = append(delta, "~ "+key+"="+value)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok && key != "_" && key != "SHLVL" {
+			delta = append(delta, "- "+key)
+		}
+	}
+	sort.Slice(delta, func(i, j int) bool { return delta[i][2:] < delta[j][2:] })
+	return delta
 }
 
-// singleLogger creats a separatly managed log (typically for an individual task to be later concatenated with the mainlog)
-func singleLogger(SingleLogChan chan LogItem, name, logPath string) {
+// writeLogIndex writes an index file to the log-dir listing the status of every task and its log file
+func writeLogIndex(tasks []*Task) {
+	if config.Options.LogDir == "" {
+		return
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("bashful run started " + startTime.Format(time.RFC3339) + "\n\n")
+
+	writeEntry := func(task *Task, indent string) {
+		status, duration, logFile := "skipped", "", "-"
+		if task.Command.Complete {
+			status = "success"
+			if task.Command.ReturnCode != 0 && !task.Config.IgnoreFailure {
+				status = "failed"
+			}
+			duration = showDuration(task.Command.StopTime.Sub(task.Command.StartTime))
+		}
+		if task.LogFile != nil {
+			logFile = filepath.Base(task.LogFile.Name())
+		}
+		buffer.WriteString(fmt.Sprintf("%-8s %8s  %s%s -> %s\n", status, duration, indent, task.Config.Name, logFile))
+	}
+
+	for _, task := range tasks {
+		if len(task.Children) > 0 {
+			buffer.WriteString(fmt.Sprintf("%-8s %8s  %s\n", "", "", task.Config.Name))
+			if task.Config.CmdString != "" {
+				writeEntry(task, "  ")
+			}
+			for _, subTask := range task.Children {
+				writeEntry(subTask, "  ")
+			}
+		} else {
+			writeEntry(task, "")
+		}
+	}
+
+	err := ioutil.WriteFile(filepath.Join(config.Options.LogDir, "index.log"), buffer.Bytes(), 0644)
+	checkError(err, "Unable to write log index")
+}
+
+// singleLogger creats a separatly managed log (typically for an individual task to be later concatenated with the mainlog).
+// The given channel is closed once the log is complete.
+func singleLogger(SingleLogChan chan LogItem, name, logPath string, written chan bool) {
+	defer close(written)
+
+	// a fatal error leaves the task without a log (see recoverFailure)
+	defer func() {
+		if recoverFailure(recover()) != "" {
+			for range SingleLogChan {
+			}
+		}
+	}()
 
 	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		exitWithErrorMessage("\nUnable to create log\n" + err.Error())
 	}
 	defer file.Close()
-	defer func() {
-		mainLogConcatChan <- LogConcat{logPath}
-	}()
+	if config.Options.LogPath != "" {
+		defer func() {
+			// the run history copies (and then removes) the task log once it was concatenated
+			done := make(chan bool)
+			mainLogConcatChan <- LogConcat{File: logPath, Keep: config.Options.LogDir != "" || config.Options.HistoryRetention > 0, Done: done}
+			<-done
+		}()
+	}
 
 	logger := log.New(file, "", log.Ldate|log.Ltime)
-	logger.Println(bold("Task full output: " + name))
+	logger.Println(logColors(bold("Task full output: " + name)))
 	logger.SetFlags(0)
 
 	for {
 		logObj, ok := <-SingleLogChan
 		if ok {
-			logger.Print(logObj.Message)
+			logger.Print(logColors(logObj.Message))
 		} else {
 			SingleLogChan = nil
 		}
@@ -103,6 +303,25 @@ func singleLogger(SingleLogChan chan LogItem, name, logPath string) {
 
 // mainLogger creates the main log configured by the `log-path` option
 func mainLogger(logPath string) {
+	// a fatal error leaves the run without a main log (see recoverFailure)
+	defer func() {
+		if recoverFailure(recover()) != "" {
+			for mainLogChan != nil || mainLogConcatChan != nil {
+				select {
+				case _, ok := <-mainLogChan:
+					if !ok {
+						mainLogChan = nil
+					}
+				case logCmd, ok := <-mainLogConcatChan:
+					if ok {
+						close(logCmd.Done)
+					} else {
+						mainLogConcatChan = nil
+					}
+				}
+			}
+		}
+	}()
 
 	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
@@ -116,7 +335,7 @@ func mainLogger(logPath string) {
 		select {
 		case logObj, ok := <-mainLogChan:
 			if ok {
-				logger.Print(logObj.Message)
+				logger.Print(logColors(logObj.Message))
 			} else {
 				mainLogChan = nil
 			}
@@ -126,6 +345,7 @@ func mainLogger(logPath string) {
 				file.Close()
 
 				out, err := exec.Command("bash", "-c", "cat "+logCmd.File+" >> "+logPath).CombinedOutput()
+				close(logCmd.Done)
 
 				if err != nil {
 					fmt.Printf("%s\n", out)
@@ -138,7 +358,9 @@ func mainLogger(logPath string) {
 				}
 				logger = log.New(file, "", log.Ldate|log.Ltime)
 
-				os.Remove(logCmd.File)
+				if !logCmd.Keep {
+					os.Remove(logCmd.File)
+				}
 			} else {
 				mainLogConcatChan = nil
 			}
@@ -148,5 +370,5 @@ func mainLogger(logPath string) {
 		}
 	}
 
-	logger.Println(bold("Finished!"))
+	logger.Println(logColors(bold("Finished!")))
 }
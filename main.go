@@ -11,7 +11,6 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"syscall"
 	"text/template"
@@ -22,7 +21,6 @@ import (
 	"github.com/mholt/archiver"
 	"github.com/spf13/afero"
 	"github.com/urfave/cli"
-	terminal "github.com/wayneashleyberry/terminal-dimensions"
 )
 
 const (
@@ -45,11 +43,12 @@ var (
 	red                = color.ColorFunc("red+h")
 	blue               = color.ColorFunc("blue+h")
 	bold               = color.ColorFunc("default+b")
-	summaryTemplate, _ = template.New("summary line").Parse(` {{.Status}}    ` + color.Reset + ` {{printf "%-16s" .Percent}}` + color.Reset + ` {{.Steps}}{{.Errors}}{{.Msg}}{{.Split}}{{.Runtime}}{{.Eta}}`)
+	summaryTemplate, _ = theme.parseTemplate("summary line", defaultFooterTemplate)
 )
 
 type summary struct {
 	Status  string
+	Symbol  string
 	Percent string
 	Msg     string
 	Runtime string
@@ -86,9 +85,7 @@ func footer(status CommandStatus, message string) string {
 		duration := time.Since(startTime)
 		durString = fmt.Sprintf(" Runtime[%s]", showDuration(duration))
 
-		totalEta := time.Duration(config.totalEtaSeconds) * time.Second
-		remainingEta := time.Duration(totalEta.Seconds()-duration.Seconds()) * time.Second
-		etaString = fmt.Sprintf(" ETA[%s]", showDuration(remainingEta))
+		etaString = fmt.Sprintf(" ETA[%s]", remainingEta())
 	}
 
 	if TaskStats.completedTasks == TaskStats.totalTasks {
@@ -104,26 +101,28 @@ func footer(status CommandStatus, message string) string {
 	}
 
 	// get a string with the summary line without a split gap (eta floats left)
-	percentValue := (float64(TaskStats.completedTasks) * float64(100)) / float64(TaskStats.totalTasks)
-	percentStr := fmt.Sprintf("%3.2f%% Complete", percentValue)
+	percentStr := fmt.Sprintf("%3.2f%% Complete", progressPercent())
 
 	if TaskStats.completedTasks == TaskStats.totalTasks {
-		percentStr = status.Color("b") + percentStr + color.Reset
+		percentStr = status.Color("b") + percentStr + resetColor()
 	} else {
 		percentStr = color.Color(percentStr, "default+b")
 	}
 
-	summaryTemplate.Execute(&tpl, summary{Status: status.Color("i"), Percent: percentStr, Runtime: durString, Eta: etaString, Steps: stepString, Errors: errorString, Msg: message})
+	if reason := throttleReason(); reason != "" && TaskStats.completedTasks < TaskStats.totalTasks {
+		message = purple(" Throttled ("+reason+")") + message
+	}
+
+	summaryTemplate.Execute(&tpl, summary{Status: status.Color("i"), Symbol: status.Symbol(), Percent: percentStr, Runtime: durString, Eta: etaString, Steps: stepString, Errors: errorString, Msg: message})
 
 	// calculate a space buffer to push the eta to the right
-	terminalWidth, _ := terminal.Width()
-	splitWidth := int(terminalWidth) - visualLength(tpl.String())
+	splitWidth := newScreen().renderer.Width() - visualLength(tpl.String())
 	if splitWidth < 0 {
 		splitWidth = 0
 	}
 
 	tpl.Reset()
-	summaryTemplate.Execute(&tpl, summary{Status: status.Color("i"), Percent: percentStr, Runtime: bold(durString), Eta: bold(etaString), Split: strings.Repeat(" ", splitWidth), Steps: bold(stepString), Errors: bold(errorString), Msg: message})
+	summaryTemplate.Execute(&tpl, summary{Status: status.Color("i"), Symbol: status.Symbol(), Percent: percentStr, Runtime: bold(durString), Eta: bold(etaString), Split: strings.Repeat(" ", splitWidth), Steps: bold(stepString), Errors: bold(errorString), Msg: message})
 
 	return tpl.String()
 }
@@ -255,13 +254,36 @@ func run(yamlString []byte, environment map[string]string) []*Task {
 
 	exitSignaled = false
 	startTime = time.Now()
+	runID = newRunID(startTime)
+	runInterrupted = false
+	resultFileWritten = false
+	historyWritten = false
+	runCompletedPublished = false
+	runCancelled = false
+	interactive.paused = false
+
+	// run may be invoked several times within the same process, start each with fresh statistics
+	TaskStats.runningCmds = 0
+	TaskStats.completedTasks = 0
+	TaskStats.totalFailedTasks = 0
+	TaskStats.totalTasks = 0
+	TaskStats.resourcesInUse = map[string]int{}
+	systemLoad.sampled = time.Time{}
 
 	ParseConfig(yamlString)
 	allTasks = CreateTasks()
+	publishRunStarted(allTasks)
+	publishSnapshot()
+	startControlSocket()
+	defer stopControlSocket()
 	storeSudoPasswd()
 
 	DownloadAssets(allTasks)
 
+	if config.Cli.Interactive {
+		startInteractive()
+	}
+
 	rand.Seed(time.Now().UnixNano())
 
 	if config.Options.UpdateInterval > 150 {
@@ -282,7 +304,7 @@ func run(yamlString []byte, environment map[string]string) []*Task {
 		tagInfo += strings.Join(config.Cli.RunTags, ", ")
 	}
 
-	fmt.Println(bold("Running " + tagInfo))
+	newScreen().Println(bold("Running " + tagInfo))
 	logToMain("Running "+tagInfo, majorFormat)
 
 	for _, task := range allTasks {
@@ -294,49 +316,64 @@ func run(yamlString []byte, environment map[string]string) []*Task {
 		}
 	}
 	logToMain("Complete", majorFormat)
+	writeLogIndex(allTasks)
 
-	err = Save(config.etaCachePath, &config.commandTimeCache)
+	err = saveEtaCache(config.etaCachePath, config.legacyEtaCachePath, config.etaCache)
 	checkError(err, "Unable to save command eta cache.")
 
 	if config.Options.ShowSummaryFooter {
 		message := ""
-		newScreen().ResetFrame(0, false, true)
+		status := statusSuccess
 		if len(failedTasks) > 0 {
 			if config.Options.LogPath != "" {
 				message = bold(" See log for details (" + config.Options.LogPath + ")")
 			}
-			newScreen().DisplayFooter(footer(statusError, message))
+			status = statusError
+		}
+
+		if config.Cli.PlainUI {
+			newScreen().Println(footer(status, message))
 		} else {
-			newScreen().DisplayFooter(footer(statusSuccess, message))
+			newScreen().ResetFrame(0, false, true)
+			newScreen().DisplayFooter(footer(status, message))
 		}
 	}
 
-	if len(failedTasks) > 0 {
-		var buffer bytes.Buffer
-		buffer.WriteString(red(" ...Some tasks failed, see below for details.\n"))
-
-		for _, task := range failedTasks {
-
-			buffer.WriteString("\n")
-			buffer.WriteString(bold(red("• Failed task: ")) + bold(task.Config.Name) + "\n")
-			buffer.WriteString(red("  ├─ command: ") + task.Config.CmdString + "\n")
-			buffer.WriteString(red("  ├─ return code: ") + strconv.Itoa(task.Command.ReturnCode) + "\n")
-			buffer.WriteString(red("  └─ stderr: ") + task.ErrorBuffer.String() + "\n")
+	if config.Options.ShowTimingReport {
+		report := timingReport(allTasks)
+		logToMain(report, "")
+		newScreen().Print("\n" + report + "\n")
+	}
 
-		}
-		logToMain(buffer.String(), "")
+	if len(failedTasks) > 0 {
+		report := failureReport(failedTasks)
+		logToMain(report, "")
 
 		// we may not show the error report, but we always log it.
 		if config.Options.ShowFailureReport {
-			fmt.Print(buffer.String())
+			newScreen().Print(report)
 		}
+	}
 
+	// the task loggers read the config of this run, so none may outlive it
+	for _, task := range commandTasks(allTasks) {
+		awaitTaskLog(task)
 	}
 
+	writeReports(allTasks, failedTasks)
+	writeResultFile()
+	writeHistory()
+	publishRunCompleted()
+	publishSnapshot()
+
 	return failedTasks
 }
 
 func exitWithErrorMessage(msg string) {
+	if isServing() {
+		// the api server fails the run instead of exiting (see runWithoutExit)
+		panic(runFailure{message: msg})
+	}
 	cleanup()
 	fmt.Fprintln(os.Stderr, red(msg))
 	os.Exit(1)
@@ -353,11 +390,23 @@ func cleanup() {
 		task.Kill()
 	}
 
+	writeResultFile()
+	writeHistory()
+	publishRunCompleted()
+	closeEventStream()
+	stopControlSocket()
+
+	if config.Cli.PlainUI {
+		return
+	}
+
+	stopInteractive()
+
 	// move the cursor past the used screen realestate
 	newScreen().MovePastFrame(true)
 
 	// show the cursor again
-	fmt.Print("\033[?25h") // show cursor
+	newScreen().Print("\033[?25h") // show cursor
 }
 
 func setup() {
@@ -365,6 +414,9 @@ func setup() {
 	signal.Notify(sigChannel, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		for sig := range sigChannel {
+			runInterrupted = true
+			// a signal always exits the process (even while serving runs)
+			setServing(false)
 			if sig == syscall.SIGINT {
 				exitWithErrorMessage(red("Keyboard Interrupt"))
 			} else if sig == syscall.SIGTERM {
@@ -410,6 +462,157 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:  "serve",
+			Usage: "Run bashful as a local agent that queues, monitors, and cancels runs requested over an http api",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen",
+					Value: ":8081",
+					Usage: "The address to serve the api on (an address without a host, e.g. ':8081', only listens on localhost).",
+				},
+				cli.StringFlag{
+					Name:  "token",
+					Value: "",
+					Usage: "Require this token on all api requests (given as '?token=...' or an 'Authorization: Bearer ...' header).",
+				},
+			},
+			Action: func(cliCtx *cli.Context) error {
+				serveAPI(cliCtx.String("listen"), cliCtx.String("token"))
+				return nil
+			},
+		},
+		{
+			Name:      "ctl",
+			Usage:     "Control a run in progress (started with the same cache path): status, cancel <task>, pause, resume, or set-parallel <n>",
+			ArgsUsage: "status|cancel <task>|pause|resume|set-parallel <n>",
+			Action: func(cliCtx *cli.Context) error {
+				if cliCtx.NArg() < 1 {
+					exitWithErrorMessage("Must provide a command: status, cancel <task>, pause, resume, or set-parallel <n>")
+				}
+
+				response, err := sendControlRequest(controlSocketPath(), cliCtx.Args())
+				if err != nil {
+					exitWithErrorMessage("Unable to control the run: " + err.Error())
+				}
+				if response.Error != "" {
+					exitWithErrorMessage(response.Error)
+				}
+				fmt.Println(response.Output)
+				return nil
+			},
+		},
+		{
+			Name:  "cache",
+			Usage: "Inspect or edit the cache (see --cache-path)",
+			Subcommands: []cli.Command{
+				{
+					Name:  "eta",
+					Usage: "List, rename, import, or clear the task runtimes used to estimate etas",
+					Action: func(cliCtx *cli.Context) error {
+						fmt.Println(etaCacheListing(readEtaCache()))
+						return nil
+					},
+					Subcommands: []cli.Command{
+						{
+							Name:  "list",
+							Usage: "List every task in the eta cache with its eta, range, and number of runs",
+							Action: func(cliCtx *cli.Context) error {
+								fmt.Println(etaCacheListing(readEtaCache()))
+								return nil
+							},
+						},
+						{
+							Name:      "rename",
+							Usage:     "Move the runtimes of a task to a new identity (e.g. after the task was renamed)",
+							ArgsUsage: "<old-task> <new-task>",
+							Action: func(cliCtx *cli.Context) error {
+								if cliCtx.NArg() != 2 {
+									exitWithErrorMessage("Must provide the current and the new task identity")
+								}
+								cache := readEtaCache()
+								if err := renameEtaCacheEntry(cache, cliCtx.Args().Get(0), cliCtx.Args().Get(1)); err != nil {
+									exitWithErrorMessage(err.Error())
+								}
+								writeEtaCache(cache)
+								return nil
+							},
+						},
+						{
+							Name:      "import",
+							Usage:     "Add all runtimes from another eta cache file (of any version, including the gob cache of older releases)",
+							ArgsUsage: "<path>",
+							Action: func(cliCtx *cli.Context) error {
+								if cliCtx.NArg() != 1 {
+									exitWithErrorMessage("Must provide the path to an eta cache file")
+								}
+								other, err := readEtaCacheFile(cliCtx.Args().Get(0))
+								checkError(err, "Unable to read the eta cache to import.")
+								cache := readEtaCache()
+								importEtaCache(cache, other)
+								writeEtaCache(cache)
+								return nil
+							},
+						},
+						{
+							Name:      "clear",
+							Usage:     "Remove the given tasks from the eta cache (or every task if none are given)",
+							ArgsUsage: "[task...]",
+							Action: func(cliCtx *cli.Context) error {
+								cache := readEtaCache()
+								if err := clearEtaCache(cache, cliCtx.Args()); err != nil {
+									exitWithErrorMessage(err.Error())
+								}
+								writeEtaCache(cache)
+								return nil
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "history",
+			Usage: "List the past runs recorded in the run history (see the 'history-retention' option)",
+			Action: func(cliCtx *cli.Context) error {
+				results, err := readHistory()
+				if err != nil {
+					exitWithErrorMessage("Unable to read the run history: " + err.Error())
+				}
+				if len(results) == 0 {
+					fmt.Println("No runs recorded in " + historyPath())
+					return nil
+				}
+				fmt.Println(historyListing(results))
+				return nil
+			},
+		},
+		{
+			Name:      "show",
+			Usage:     "Show a past run (given by its id, or 'last') or the output of one of its tasks",
+			ArgsUsage: "<run-id>|last [task]",
+			Action: func(cliCtx *cli.Context) error {
+				if cliCtx.NArg() < 1 || cliCtx.NArg() > 2 {
+					exitWithErrorMessage("Must provide a run id (or 'last') and optionally a task name")
+				}
+
+				result, err := findHistoryRun(cliCtx.Args().Get(0))
+				if err != nil {
+					exitWithErrorMessage(err.Error())
+				}
+				if cliCtx.NArg() == 1 {
+					fmt.Println(historyRunSummary(result))
+					return nil
+				}
+
+				output, err := historyTaskOutput(result, cliCtx.Args().Get(1))
+				if err != nil {
+					exitWithErrorMessage(err.Error())
+				}
+				fmt.Print(output)
+				return nil
+			},
+		},
 		{
 			Name:  "run",
 			Usage: "Execute the given yaml file with bashful",
@@ -424,6 +627,43 @@ func main() {
 					Value: "",
 					Usage: "A comma delimited list of matching task tags. A task will only be executed if it has a matching tag.",
 				},
+				cli.BoolFlag{
+					Name:  "interactive",
+					Usage: "Use the keyboard to select, inspect the output of, and cancel running tasks (press '?' while running for help).",
+				},
+				cli.StringSliceFlag{
+					Name:  "report",
+					Usage: "Write a report of every task after the run as 'junit=path' (junit xml) or 'tap=path' (test anything protocol). Can be given more than once.",
+				},
+				cli.StringFlag{
+					Name:  "events",
+					Value: "",
+					Usage: "Write every run event (task queued/started/output/completed/skipped, download progress, run completed) as newline-delimited json to a file path, an open file descriptor ('fd:N'), or a listening unix socket ('unix:path').",
+				},
+				cli.StringFlag{
+					Name:  "result-file",
+					Value: "",
+					Usage: "Write a json document describing the outcome of the run and every task to the given path (also written when the run is aborted).",
+				},
+				cli.StringFlag{
+					Name:  "serve",
+					Value: "",
+					Usage: "Host a web dashboard on the given address (e.g. ':8080', which only listens on localhost) showing the task tree, statuses, etas, and live output.",
+				},
+				cli.StringFlag{
+					Name:  "serve-token",
+					Value: "",
+					Usage: "Require this token on all dashboard requests (given as '?token=...' or an 'Authorization: Bearer ...' header).",
+				},
+				cli.BoolFlag{
+					Name:  "rerun-failed",
+					Usage: "Only run the tasks that did not succeed in the last run of the same yaml file (see 'bashful history').",
+				},
+				cli.StringFlag{
+					Name:  "ui",
+					Value: "auto",
+					Usage: "How progress is shown: 'fancy' (redrawn frame), 'plain' (append-only lines, e.g. for CI logs), or 'auto' (plain when stdout is not a terminal).",
+				},
 			},
 			Action: func(cliCtx *cli.Context) error {
 				if cliCtx.NArg() < 1 {
@@ -450,6 +690,44 @@ func main() {
 					}
 				}
 
+				switch cliCtx.String("ui") {
+				case "auto":
+					config.Cli.PlainUI = !isTerminal(os.Stdout)
+				case "plain":
+					config.Cli.PlainUI = true
+				case "fancy":
+					config.Cli.PlainUI = false
+				default:
+					exitWithErrorMessage("Option 'ui' must be one of: auto, fancy, plain")
+				}
+
+				config.Cli.Reports = make(map[string]string)
+				for _, value := range cliCtx.StringSlice("report") {
+					fields := strings.SplitN(value, "=", 2)
+					if len(fields) != 2 || fields[1] == "" || (fields[0] != "junit" && fields[0] != "tap") {
+						exitWithErrorMessage("Option 'report' must be given as 'junit=path' or 'tap=path' (got '" + value + "')")
+					}
+					config.Cli.Reports[fields[0]] = fields[1]
+				}
+
+				config.Cli.ResultFile = cliCtx.String("result-file")
+
+				if cliCtx.String("serve") != "" {
+					serveDashboard(cliCtx.String("serve"), cliCtx.String("serve-token"))
+				} else if cliCtx.String("serve-token") != "" {
+					exitWithErrorMessage("Option 'serve-token' can only be used with 'serve'")
+				}
+
+				if cliCtx.String("events") != "" {
+					err := openEventStream(cliCtx.String("events"))
+					checkError(err, "Unable to open the event stream: "+cliCtx.String("events"))
+				}
+
+				config.Cli.Interactive = cliCtx.Bool("interactive")
+				if config.Cli.Interactive && config.Cli.PlainUI {
+					exitWithErrorMessage("Option 'interactive' requires a terminal (and cannot be used with plain output)")
+				}
+
 				// Since this is an empty map, no env vars will be loaded explicitly into the first exec.Command
 				// which will cause the current processes env vars to be loaded instead
 				environment := map[string]string{}
@@ -457,7 +735,24 @@ func main() {
 				yamlString, err := ioutil.ReadFile(userYamlPath)
 				checkError(err, "Unable to read yaml config.")
 
-				fmt.Print("\033[?25l") // hide cursor
+				config.Cli.YamlPath, err = filepath.Abs(userYamlPath)
+				checkError(err, "Unable to find the path of the yaml config.")
+
+				if cliCtx.Bool("rerun-failed") {
+					config.Cli.RerunTasks, err = rerunTasks(config.Cli.YamlPath)
+					if err != nil {
+						exitWithErrorMessage("Unable to rerun the failed tasks: " + err.Error())
+					}
+					if config.Cli.RerunTasks.Cardinality() == 0 {
+						fmt.Println("All tasks succeeded in the last run of " + userYamlPath + ", nothing to rerun")
+						return nil
+					}
+				}
+
+				if !config.Cli.PlainUI {
+					newScreen().Print("\033[?25l") // hide cursor
+					watchTerminalResize()
+				}
 				failedTasks := run(yamlString, environment)
 
 				logToMain("Exiting", "")
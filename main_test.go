@@ -1,8 +1,14 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/alecthomas/repr"
 )
 
 func TestTaskErrorPolicy(t *testing.T) {
@@ -53,3 +59,131 @@ tasks:
 	}
 
 }
+
+func TestLogDir(t *testing.T) {
+	logDir, err := ioutil.TempDir("", "bashful-logs")
+	if err != nil {
+		t.Fatal("TestLogDir: unable to create temp dir:", err)
+	}
+	defer os.RemoveAll(logDir)
+
+	simpleYamlStr := `
+config:
+  log-dir: ` + logDir + `
+  stop-on-failure: false
+tasks:
+  - name: first task
+    cmd: true
+  - name: Some group
+    parallel-tasks:
+      - name: failing/task
+        cmd: false
+      - name: first task
+        cmd: true
+`
+	failedTasks := run([]byte(simpleYamlStr), map[string]string{})
+	if len(failedTasks) != 1 {
+		t.Fatal("TestLogDir: Expected exactly 1 task to fail, got " + strconv.Itoa(len(failedTasks)))
+	}
+
+	for _, filename := range []string{"first-task.log", "failing-task.log", "first-task-2.log", "index.log"} {
+		if !doesFileExist(filepath.Join(logDir, filename)) {
+			t.Error("TestLogDir: Expected log file", filename, "to exist")
+		}
+	}
+
+	if failedTasks[0].LogFile.Name() != filepath.Join(logDir, "failing-task.log") {
+		t.Error("TestLogDir: Expected failed task log to be retained, got", failedTasks[0].LogFile.Name())
+	}
+
+	index, _ := ioutil.ReadFile(filepath.Join(logDir, "index.log"))
+	if !strings.Contains(string(index), "failing/task -> failing-task.log") {
+		t.Error("TestLogDir: Expected index to reference the failed task log, got", string(index))
+	}
+}
+
+func TestPreserveColors(t *testing.T) {
+	logDir, err := ioutil.TempDir("", "bashful-logs")
+	if err != nil {
+		t.Fatal("TestPreserveColors: unable to create temp dir:", err)
+	}
+	defer os.RemoveAll(logDir)
+
+	simpleYamlStr := `
+config:
+  log-dir: ` + logDir + `
+  log-colors: strip
+tasks:
+  - name: colorful
+    cmd: printf '\033[32mpassed\033[0m \033[5mblink\033[2A\n'
+    preserve-colors: true
+  - name: plain
+    cmd: printf '\033[32mpassed\033[0m\n'
+`
+	run([]byte(simpleYamlStr), map[string]string{})
+
+	tasks := allTasks
+	expected := "\x1b[32mpassed\x1b[0m blink\x1b[0m"
+	if actual := tasks[0].CapturedOutput(); len(actual) != 1 || actual[0] != expected {
+		t.Error("TestPreserveColors: Expected sanitized task colors", repr.String(expected), "got", repr.String(actual))
+	}
+	expected = blue("passed")
+	if actual := tasks[1].CapturedOutput(); len(actual) != 1 || actual[0] != expected {
+		t.Error("TestPreserveColors: Expected recolored output", repr.String(expected), "got", repr.String(actual))
+	}
+
+	taskLog, _ := ioutil.ReadFile(filepath.Join(logDir, "colorful.log"))
+	if strings.Contains(string(taskLog), "\x1b") || !strings.Contains(string(taskLog), "passed blink") {
+		t.Error("TestPreserveColors: Expected the log to be stripped of colors, got", repr.String(string(taskLog)))
+	}
+}
+
+func TestEnvironmentDelta(t *testing.T) {
+	before := map[string]string{"KEEP": "same", "CHANGE": "old", "REMOVE": "x", "SHLVL": "1"}
+	after := map[string]string{"KEEP": "same", "CHANGE": "new", "ADD": "y", "SHLVL": "2"}
+
+	expected := []string{"+ ADD=y", "~ CHANGE=new", "- REMOVE"}
+	actual := environmentDelta(before, after)
+	if strings.Join(expected, ",") != strings.Join(actual, ",") {
+		t.Error("TestEnvironmentDelta: Expected", expected, "got", actual)
+	}
+}
+
+func TestPlainOutput(t *testing.T) {
+	simpleYamlStr := `
+config:
+  stop-on-failure: false
+tasks:
+  - name: say hello
+    cmd: echo hello
+  - name: build
+    parallel-tasks:
+      - name: broken
+        cmd: echo oops >&2; false
+`
+	config.Cli.PlainUI = true
+	defer func() { config.Cli.PlainUI = false }()
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	failedTasks := run([]byte(simpleYamlStr), map[string]string{})
+	w.Close()
+	os.Stdout = old
+	output, _ := ioutil.ReadAll(r)
+
+	if len(failedTasks) != 1 {
+		t.Error("TestPlainOutput: Expected exactly 1 task to fail, got " + strconv.Itoa(len(failedTasks)))
+	}
+
+	for _, expected := range []string{"[say hello] Started: echo hello", "hello", "[broken] oops", "Failed with return code 1", "[build] Started (1 parallel tasks)"} {
+		if !strings.Contains(string(output), expected) {
+			t.Error("TestPlainOutput: Expected output to contain", repr.String(expected), "got", repr.String(string(output)))
+		}
+	}
+
+	// the output is not written to a terminal (there are no colors either)
+	if strings.Contains(string(output), "\x1b") {
+		t.Error("TestPlainOutput: Expected no escape sequences, got", repr.String(string(output)))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// isTerminal indicates if the given file is attached to a terminal (and can interpret ansi control codes)
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// plainOutput returns the given output as written to stdout: plain output to something other than a terminal (e.g. a CI
+// log) has every escape sequence (such as colors) removed
+func plainOutput(p []byte) []byte {
+	if !config.Cli.PlainUI || isTerminal(os.Stdout) {
+		return p
+	}
+	return []byte(stripEscapes(string(p)))
+}
+
+// plainPrefix is the tag placed in front of every plain output line for the given task (e.g. "[build]")
+func plainPrefix(task *Task) string {
+	return bold("[" + task.Config.Name + "]")
+}
+
+// plainDisplayStart prints an append-only line indicating that the given task (or group of tasks) has started
+func plainDisplayStart(task *Task) {
+	if len(task.Children) > 0 {
+		newScreen().Println(plainPrefix(task), "Started ("+strconv.Itoa(len(task.Children))+" parallel tasks)")
+	} else {
+		newScreen().Println(plainPrefix(task), "Started:", task.Config.CmdString)
+	}
+}
+
+// plainDisplayEvent prints the given command event as an append-only line (instead of redrawing the screen frame)
+func plainDisplayEvent(event CmdEvent) {
+	task := event.Task
+
+	if event.Complete {
+		duration := showDuration(task.Command.StopTime.Sub(task.Command.StartTime))
+		if event.Status == statusError {
+			newScreen().Println(plainPrefix(task), red("Failed with return code "+strconv.Itoa(event.ReturnCode)), "("+duration+")")
+		} else {
+			newScreen().Println(plainPrefix(task), "Completed", "("+duration+")")
+		}
+		return
+	}
+
+	if !task.Config.ShowTaskOutput {
+		return
+	}
+
+	if event.Stderr != "" {
+		newScreen().Println(plainPrefix(task), event.Stderr)
+	} else if event.Stdout != "" {
+		newScreen().Println(plainPrefix(task), event.Stdout)
+	}
+}
+
+// plainDisplayGroupComplete prints an append-only line indicating that all tasks in the given group have completed
+func plainDisplayGroupComplete(task *Task) {
+	if len(task.failedTasks) > 0 {
+		newScreen().Println(plainPrefix(task), red("Failed ("+strconv.Itoa(len(task.failedTasks))+" of "+strconv.Itoa(len(task.Children))+" tasks failed)"))
+	} else {
+		newScreen().Println(plainPrefix(task), "Completed")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timingRow is a single task runtime shown on the timing report
+type timingRow struct {
+	name    string
+	group   string
+	runtime time.Duration
+	eta     time.Duration
+}
+
+// showRuntime formats the given duration for the timing report (sub-second tasks are still distinguishable)
+func showRuntime(duration time.Duration) string {
+	return duration.Round(10 * time.Millisecond).String()
+}
+
+// showRuntimeDelta formats the difference between a runtime and its eta (e.g. "+1.5s" when slower than expected)
+func showRuntimeDelta(runtime, eta time.Duration) string {
+	// the delta is taken between the shown (rounded) values so that the columns agree with one another
+	delta := runtime.Round(10*time.Millisecond) - eta.Round(10*time.Millisecond)
+	if delta < 0 {
+		return "-" + showRuntime(-delta)
+	}
+	return "+" + showRuntime(delta)
+}
+
+// timingReport returns a table of the runtime of every completed task (slowest first) compared with the eta from
+// previous runs, followed by the time spent within each group of parallel tasks
+func timingReport(tasks []*Task) string {
+	var rows []timingRow
+	var groups []*Task
+
+	for _, task := range tasks {
+		if task.Config.CmdString != "" && task.Command.Complete {
+			rows = append(rows, timingRow{name: task.Config.Name, runtime: task.Command.StopTime.Sub(task.Command.StartTime), eta: task.Command.EstimatedRuntime})
+		}
+		if len(task.Children) > 0 && !task.groupStopTime.IsZero() {
+			groups = append(groups, task)
+		}
+		for _, subTask := range task.Children {
+			if subTask.Command.Complete {
+				rows = append(rows, timingRow{name: subTask.Config.Name, group: task.Config.Name, runtime: subTask.Command.StopTime.Sub(subTask.Command.StartTime), eta: subTask.Command.EstimatedRuntime})
+			}
+		}
+	}
+
+	if len(rows) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].runtime > rows[j].runtime
+	})
+
+	// the first row holds the column titles
+	table := [][]string{{"Task", "Group", "Runtime", "ETA", "Delta"}}
+	for _, row := range rows {
+		eta, delta := "-", "-"
+		if row.eta >= 0 {
+			eta = showRuntime(row.eta)
+			delta = showRuntimeDelta(row.runtime, row.eta)
+		}
+		table = append(table, []string{row.name, row.group, showRuntime(row.runtime), eta, delta})
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(bold(" Timing report (slowest first)") + "\n")
+	writeTable(&buffer, table)
+
+	if len(groups) > 0 {
+		table = [][]string{{"Parallel group", "Tasks", "Runtime", "Task time", "Speedup"}}
+		for _, group := range groups {
+			var taskTime time.Duration
+			for _, subTask := range group.Children {
+				if subTask.Command.Complete {
+					taskTime += subTask.Command.StopTime.Sub(subTask.Command.StartTime)
+				}
+			}
+			runtime := group.groupStopTime.Sub(group.groupStartTime)
+			speedup := "-"
+			if runtime > 0 {
+				speedup = strconv.FormatFloat(taskTime.Seconds()/runtime.Seconds(), 'f', 1, 64) + "x"
+			}
+			table = append(table, []string{group.Config.Name, strconv.Itoa(len(group.Children)), showRuntime(runtime), showRuntime(taskTime), speedup})
+		}
+		buffer.WriteString("\n")
+		writeTable(&buffer, table)
+	}
+
+	return buffer.String()
+}
+
+// writeTable writes the given rows as aligned columns (the first row is shown in bold as the column titles)
+func writeTable(buffer *bytes.Buffer, table [][]string) {
+	widths := make([]int, len(table[0]))
+	for _, row := range table {
+		for idx, cell := range row {
+			if visualLength(cell) > widths[idx] {
+				widths[idx] = visualLength(cell)
+			}
+		}
+	}
+
+	for rowIdx, row := range table {
+		cells := make([]string, len(row))
+		for idx, cell := range row {
+			cells[idx] = padToVisualLength(cell, widths[idx])
+		}
+		line := "   " + strings.TrimRight(strings.Join(cells, "   "), " ")
+		if rowIdx == 0 {
+			line = bold(line)
+		}
+		buffer.WriteString(line + "\n")
+	}
+}
+
+// maxReportCommandLength is the longest single-line command shown as-is on the failure report
+const maxReportCommandLength = 80
+
+// reportItem is a single labeled value on the failure report (with any lines shown beneath it)
+type reportItem struct {
+	label string
+	value string
+	lines []string
+}
+
+// failureReport returns the details of every failed task: the command (as a numbered script when long), how it ran, and
+// the last lines of its interleaved stdout/stderr
+func failureReport(failedTasks []*Task) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(red(" ...Some tasks failed, see below for details.\n"))
+
+	for _, task := range failedTasks {
+		var items []reportItem
+
+		if script := commandScript(task.Config.CmdString); len(script) > 0 {
+			items = append(items, reportItem{label: "command:", lines: script})
+		} else {
+			items = append(items, reportItem{label: "command:", value: task.Config.CmdString})
+		}
+		items = append(items, reportItem{label: "return code:", value: strconv.Itoa(task.Command.ReturnCode)})
+		if !task.Command.StartTime.IsZero() && !task.Command.StopTime.IsZero() {
+			items = append(items, reportItem{label: "duration:", value: showRuntime(task.Command.StopTime.Sub(task.Command.StartTime))})
+		}
+		if workingDir := taskWorkingDir(task); workingDir != "" {
+			items = append(items, reportItem{label: "working dir:", value: workingDir})
+		}
+		if len(task.Command.EnvironmentDelta) > 0 {
+			items = append(items, reportItem{label: "environment:", lines: task.Command.EnvironmentDelta})
+		}
+		if config.Options.LogDir != "" && task.LogFile != nil {
+			items = append(items, reportItem{label: "full log:", value: task.LogFile.Name()})
+		} else if config.Options.LogPath != "" {
+			items = append(items, reportItem{label: "full log:", value: config.Options.LogPath})
+		}
+		if output := task.CapturedOutput(); len(output) > 0 && config.Options.FailureReportLines > 0 {
+			label := "output:"
+			if len(output) > config.Options.FailureReportLines {
+				output = output[len(output)-config.Options.FailureReportLines:]
+				label = "output (last " + strconv.Itoa(len(output)) + " lines):"
+			}
+			items = append(items, reportItem{label: label, lines: output})
+		}
+
+		buffer.WriteString("\n")
+		buffer.WriteString(bold(red("• Failed task: ")) + bold(task.Config.Name) + "\n")
+		for idx, item := range items {
+			branch, trunk := "  ├─ ", "  │    "
+			if idx == len(items)-1 {
+				branch, trunk = "  └─ ", "       "
+			}
+			if item.value != "" {
+				buffer.WriteString(red(branch+item.label+" ") + item.value + "\n")
+			} else {
+				buffer.WriteString(red(branch+item.label) + "\n")
+			}
+			for _, line := range item.lines {
+				buffer.WriteString(red(trunk) + line + resetColor() + "\n")
+			}
+		}
+	}
+	return buffer.String()
+}
+
+// taskWorkingDir returns the directory that the given task command was run from
+func taskWorkingDir(task *Task) string {
+	if task.Command.Cmd != nil && task.Command.Cmd.Dir != "" {
+		return task.Command.Cmd.Dir
+	}
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return workingDir
+}
+
+// commandScript splits a multi-line or long command into numbered lines (long single-line commands are split after
+// each unquoted '&&', '||', or ';'). Nothing is returned for a command that fits on a single report line.
+func commandScript(cmd string) []string {
+	lines := strings.Split(strings.TrimRight(cmd, "\n"), "\n")
+	if len(lines) == 1 {
+		if visualLength(cmd) <= maxReportCommandLength {
+			return nil
+		}
+		lines = splitCommandList(cmd)
+		if len(lines) == 1 {
+			return nil
+		}
+	}
+
+	width := len(strconv.Itoa(len(lines)))
+	script := make([]string, len(lines))
+	for idx, line := range lines {
+		number := strconv.Itoa(idx + 1)
+		script[idx] = strings.Repeat(" ", width-len(number)) + number + "  " + line
+	}
+	return script
+}
+
+// splitCommandList splits a single-line shell command after each '&&', '||', or ';' that is not within quotes
+func splitCommandList(cmd string) (lines []string) {
+	var quote rune
+	escaped := false
+	start := 0
+	runes := []rune(cmd)
+	for idx := 0; idx < len(runes); idx++ {
+		char := runes[idx]
+		switch {
+		case escaped:
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case char == ';' || ((char == '&' || char == '|') && idx+1 < len(runes) && runes[idx+1] == char):
+			if char != ';' {
+				idx++
+			}
+			if line := strings.TrimSpace(string(runes[start : idx+1])); line != "" {
+				lines = append(lines, line)
+			}
+			start = idx + 1
+		}
+	}
+	if line := strings.TrimSpace(string(runes[start:])); line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestTimingReport(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	completed := func(name string, runtime, eta time.Duration) *Task {
+		task := NewTask(TaskConfig{Name: name, CmdString: "true"}, 1, "")
+		task.Command.Complete = true
+		task.Command.StartTime = start
+		task.Command.StopTime = start.Add(runtime)
+		task.Command.EstimatedRuntime = eta
+		return task
+	}
+
+	group := NewTask(TaskConfig{Name: "Compiling"}, 1, "")
+	group.Children = []*Task{
+		completed("compile a", 2*time.Second, 1500*time.Millisecond),
+		completed("compile b", 4*time.Second, -1),
+	}
+	group.groupStartTime = start
+	group.groupStopTime = start.Add(4 * time.Second)
+
+	notRun := NewTask(TaskConfig{Name: "skipped", CmdString: "true"}, 1, "")
+	tasks := []*Task{completed("setup", 3*time.Second, 5*time.Second), group, notRun}
+
+	expected := strings.Join([]string{
+		" Timing report (slowest first)",
+		"   Task        Group       Runtime   ETA    Delta",
+		"   compile b   Compiling   4s        -      -",
+		"   setup                   3s        5s     -2s",
+		"   compile a   Compiling   2s        1.5s   +500ms",
+		"",
+		"   Parallel group   Tasks   Runtime   Task time   Speedup",
+		"   Compiling        2       4s        6s          1.5x",
+		"",
+	}, "\n")
+
+	actual := stripEscapes(timingReport(tasks))
+	if expected != actual {
+		t.Error("TestTimingReport: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+
+	if timingReport([]*Task{notRun}) != "" {
+		t.Error("TestTimingReport: Expected no report without any completed tasks")
+	}
+}
+
+func TestFailureReport(t *testing.T) {
+	defer func() { config.Options = NewOptionsConfig() }()
+	config.Options = NewOptionsConfig()
+	config.Options.FailureReportLines = 2
+
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	task := NewTask(TaskConfig{Name: "deploy", CmdString: "cd build\nmake install"}, 1, "")
+	task.Command.ReturnCode = 2
+	task.Command.StartTime = start
+	task.Command.StopTime = start.Add(1500 * time.Millisecond)
+	task.Command.Cmd.Dir = "/srv/app"
+	task.Command.EnvironmentDelta = []string{"+ STAGE=build"}
+	for _, line := range []string{"compiling", "installing", "permission denied"} {
+		task.captureOutput(line)
+	}
+
+	expected := strings.Join([]string{
+		" ...Some tasks failed, see below for details.",
+		"",
+		"• Failed task: deploy",
+		"  ├─ command:",
+		"  │    1  cd build",
+		"  │    2  make install",
+		"  ├─ return code: 2",
+		"  ├─ duration: 1.5s",
+		"  ├─ working dir: /srv/app",
+		"  ├─ environment:",
+		"  │    + STAGE=build",
+		"  └─ output (last 2 lines):",
+		"       installing",
+		"       permission denied",
+		"",
+	}, "\n")
+
+	actual := stripEscapes(failureReport([]*Task{task}))
+	if expected != actual {
+		t.Error("TestFailureReport: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+}
+
+func TestCommandScript(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		expected []string
+	}{
+		{"make test", nil},
+		{"echo one\necho two\n", []string{"1  echo one", "2  echo two"}},
+		{"./configure --prefix=/usr/local && make -j8 && echo 'built; installing' || exit 1; make install", []string{
+			"1  ./configure --prefix=/usr/local &&",
+			"2  make -j8 &&",
+			"3  echo 'built; installing' ||",
+			"4  exit 1;",
+			"5  make install",
+		}},
+		{"echo " + strings.Repeat("x", 100), nil},
+	}
+
+	for _, test := range tests {
+		actual := commandScript(test.cmd)
+		if repr.String(test.expected) != repr.String(actual) {
+			t.Error("TestCommandScript: Expected", repr.String(test.expected), "got", repr.String(actual), "for", repr.String(test.cmd))
+		}
+	}
+}
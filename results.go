@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// runID uniquely identifies the current run (set when the run starts)
+	runID string
+
+	// runInterrupted indicates that the run was stopped by a signal (e.g. a keyboard interrupt) before all tasks completed
+	runInterrupted bool
+
+	// resultFileWritten indicates that the result file for the current run already exists (it is written only once)
+	resultFileWritten bool
+
+	// lastRunID is the id of the previous run started by this process (runs may start within the same second)
+	lastRunID string
+
+	// runIDSequence counts the runs started by this process within the same second as the previous run
+	runIDSequence int
+)
+
+// runResult is the document written to the result file (see the 'result-file' option) describing the outcome of a run
+type runResult struct {
+	RunID     string                 `json:"run-id"`
+	Yaml      string                 `json:"yaml,omitempty"`
+	Status    string                 `json:"status"`
+	StartTime time.Time              `json:"start-time"`
+	StopTime  time.Time              `json:"stop-time"`
+	Duration  float64                `json:"duration-seconds"`
+	Tags      []string               `json:"tags,omitempty"`
+	OnlyTags  bool                   `json:"only-tags,omitempty"`
+	Args      []string               `json:"args,omitempty"`
+	Options   map[string]interface{} `json:"options"`
+	Tasks     []taskResult           `json:"tasks"`
+}
+
+// taskResult is the outcome of a single task within a runResult
+type taskResult struct {
+	ID         string     `json:"id,omitempty"`
+	Name       string     `json:"name"`
+	Group      string     `json:"group,omitempty"`
+	Command    string     `json:"command"`
+	Tags       []string   `json:"tags,omitempty"`
+	Status     string     `json:"status"`
+	ReturnCode int        `json:"return-code"`
+	StartTime  *time.Time `json:"start-time,omitempty"`
+	StopTime   *time.Time `json:"stop-time,omitempty"`
+	LogPath    string     `json:"log-path,omitempty"`
+}
+
+// newRunID creates an id for a run that starts at the given time (unique to this host)
+func newRunID(start time.Time) string {
+	id := start.Format("20060102-150405") + "-" + strconv.Itoa(os.Getpid())
+	if id == lastRunID {
+		runIDSequence++
+		return id + "-" + strconv.Itoa(runIDSequence)
+	}
+	lastRunID = id
+	runIDSequence = 0
+	return id
+}
+
+// taskStatus describes the state of the given task command: success, failed, cancelled, running (the run stopped while
+// the command was running), or not-run
+func taskStatus(task *Task) string {
+	switch {
+	case task.Command.Cancelled:
+		return "cancelled"
+	case task.Command.Complete && (task.Command.ReturnCode == 0 || task.Config.IgnoreFailure):
+		return "success"
+	case task.Command.Complete:
+		return "failed"
+	case task.Command.Started:
+		return "running"
+	}
+	return "not-run"
+}
+
+// newTaskResult describes the outcome of the given task (the group is the name of the parallel task it belongs to)
+func newTaskResult(task *Task, group string) taskResult {
+	result := taskResult{
+		ID:         task.Config.identity,
+		Name:       task.Config.Name,
+		Group:      group,
+		Command:    task.Config.CmdString,
+		Tags:       task.Config.Tags,
+		Status:     taskStatus(task),
+		ReturnCode: task.Command.ReturnCode,
+	}
+	if task.Command.Started {
+		startTime := task.Command.StartTime
+		result.StartTime = &startTime
+	}
+	if task.Command.Complete {
+		stopTime := task.Command.StopTime
+		result.StopTime = &stopTime
+	}
+	if config.Options.LogDir != "" && task.LogFile != nil {
+		result.LogPath = task.LogFile.Name()
+	}
+	return result
+}
+
+// optionValues returns all config options keyed by their yaml names
+func optionValues(options OptionsConfig) map[string]interface{} {
+	values := make(map[string]interface{})
+	data, err := yaml.Marshal(options)
+	if err == nil {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return nil
+	}
+	// the number of parallel commands the run used (rather than an expression such as 'cpus-1')
+	values["max-parallel-commands"] = options.MaxParallelCmds
+	return values
+}
+
+// newRunResult describes the outcome of the current run and every task within it (including the tasks pruned by tags)
+func newRunResult(tasks []*Task, stopTime time.Time) runResult {
+	result := runResult{
+		RunID:     runID,
+		Yaml:      config.Cli.YamlPath,
+		Status:    "success",
+		StartTime: startTime,
+		StopTime:  stopTime,
+		Duration:  stopTime.Sub(startTime).Seconds(),
+		Tags:      config.Cli.RunTags,
+		OnlyTags:  config.Cli.ExecuteOnlyMatchedTags,
+		Args:      config.Cli.Args,
+		Options:   optionValues(config.Options),
+		Tasks:     []taskResult{},
+	}
+
+	for _, task := range tasks {
+		if task.Config.CmdString != "" {
+			result.Tasks = append(result.Tasks, newTaskResult(task, ""))
+		}
+		for _, subTask := range task.Children {
+			result.Tasks = append(result.Tasks, newTaskResult(subTask, task.Config.Name))
+		}
+	}
+	for _, pruned := range config.prunedTasks {
+		result.Tasks = append(result.Tasks, taskResult{Name: pruned.Config.Name, Group: pruned.Group, Command: pruned.Config.CmdString, Tags: pruned.Config.Tags, Status: "skipped", ReturnCode: -1})
+	}
+
+	for _, task := range result.Tasks {
+		if task.Status == "failed" || task.Status == "cancelled" {
+			result.Status = "failed"
+		}
+	}
+	if runInterrupted {
+		result.Status = "interrupted"
+	}
+	return result
+}
+
+// writeResultFile writes the outcome of the current run to the result file (if one was requested and the run has
+// started). Since this is called while exiting, any error is only reported (never exits).
+func writeResultFile() {
+	if config.Cli.ResultFile == "" || runID == "" || resultFileWritten {
+		return
+	}
+	resultFileWritten = true
+
+	data, err := json.MarshalIndent(newRunResult(allTasks, time.Now()), "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(config.Cli.ResultFile, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, red("Unable to write result file: "+err.Error()))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestRunResult(t *testing.T) {
+	defer func() {
+		config.prunedTasks = nil
+		runInterrupted = false
+	}()
+
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	startTime = start
+	runID = newRunID(start)
+	config.Options = NewOptionsConfig()
+	config.prunedTasks = []prunedTask{{Config: TaskConfig{Name: "deploy", CmdString: "./deploy.sh", Tags: []string{"prod"}}}}
+
+	newTestTask := func(name string, started, complete bool, returnCode int) *Task {
+		task := NewTask(TaskConfig{Name: name, CmdString: "true"}, 1, "")
+		task.Command.Started = started
+		task.Command.Complete = complete
+		task.Command.ReturnCode = returnCode
+		task.Command.StartTime = start
+		task.Command.StopTime = start.Add(time.Second)
+		return task
+	}
+
+	group := NewTask(TaskConfig{Name: "Compiling"}, 1, "")
+	group.Children = []*Task{newTestTask("compile a", true, true, 0), newTestTask("compile b", true, true, 3), newTestTask("compile c", true, false, -1)}
+	tasks := []*Task{newTestTask("setup", true, true, 0), group, newTestTask("after", false, false, -1)}
+
+	result := newRunResult(tasks, start.Add(2*time.Second))
+
+	var actual []string
+	for _, task := range result.Tasks {
+		actual = append(actual, task.Group+"/"+task.Name+":"+task.Status)
+	}
+	expected := []string{"/setup:success", "Compiling/compile a:success", "Compiling/compile b:failed", "Compiling/compile c:running", "/after:not-run", "/deploy:skipped"}
+	if repr.String(expected) != repr.String(actual) {
+		t.Error("TestRunResult: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+	if result.Status != "failed" || result.Duration != 2 {
+		t.Error("TestRunResult: Expected a failed run of 2 seconds, got", result.Status, result.Duration)
+	}
+	if result.Tasks[0].StartTime == nil || result.Tasks[4].StartTime != nil {
+		t.Error("TestRunResult: Expected only started tasks to have a start time")
+	}
+	if result.Options["max-parallel-commands"] != 4 {
+		t.Error("TestRunResult: Expected options by their yaml names, got", repr.String(result.Options))
+	}
+
+	runInterrupted = true
+	if newRunResult(tasks, start).Status != "interrupted" {
+		t.Error("TestRunResult: Expected an interrupted run")
+	}
+}
+
+func TestResultFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bashful-result")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		config.Cli = CliOptions{}
+	}()
+	config.Cli.ResultFile = filepath.Join(dir, "result.json")
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	scr.SetRenderer(newVirtualTerminal(80, 24))
+
+	// the result file is written once the run completes (not only while exiting)
+	run([]byte("tasks:\n  - name: hello\n    cmd: echo hello\n"), map[string]string{})
+
+	data, err := ioutil.ReadFile(config.Cli.ResultFile)
+	var result runResult
+	if err == nil {
+		err = json.Unmarshal(data, &result)
+	}
+	if err != nil || result.RunID != runID || result.Status != "success" || len(result.Tasks) != 1 || result.Tasks[0].Name != "hello" {
+		t.Error("TestResultFile: Expected the result of the run, got", repr.String(string(data)), err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// scheduleFifo starts the child tasks of a parallel task in the order they are listed
+	scheduleFifo = "fifo"
+
+	// scheduleLongestFirst starts the child tasks with the longest eta first (the group tends to finish soonest when the
+	// longest tasks are not left for last)
+	scheduleLongestFirst = "longest-first"
+
+	// scheduleShortestFirst starts the child tasks with the shortest eta first (the most tasks finish early)
+	scheduleShortestFirst = "shortest-first"
+)
+
+// schedules are all values of the 'schedule' option
+var schedules = []string{scheduleFifo, scheduleLongestFirst, scheduleShortestFirst}
+
+// validSchedule indicates if the given value is a known 'schedule' option value
+func validSchedule(schedule string) bool {
+	for _, value := range schedules {
+		if value == schedule {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleTasks returns the given tasks in the order they should be started given the 'schedule' option. Tasks with an
+// unknown eta are started after all others, and tasks with the same eta keep the order they are listed in.
+func scheduleTasks(tasks []*Task, schedule string) []*Task {
+	ordered := append([]*Task{}, tasks...)
+	if schedule != scheduleLongestFirst && schedule != scheduleShortestFirst {
+		return ordered
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		first, second := ordered[i].Command.EstimatedRuntime, ordered[j].Command.EstimatedRuntime
+		switch {
+		case first < 0 || second < 0:
+			return second < 0 && first >= 0
+		case schedule == scheduleLongestFirst:
+			return first > second
+		default:
+			return first < second
+		}
+	})
+	return ordered
+}
+
+// scheduledChildren returns the child tasks in the order they are started (decided once, given the eta of each task)
+func (task *Task) scheduledChildren() []*Task {
+	if task.startOrder == nil {
+		task.startOrder = scheduleTasks(task.Children, task.Config.Schedule)
+	}
+	return task.startOrder
+}
+
+// canStart indicates if the given command (the task command or a child task command) may be started now, given the
+// max-parallel-commands limit, the max-parallel limit of the task, and the limit of each resource the command uses
+func (task *Task) canStart(command *Task) bool {
+	if TaskStats.runningCmds >= config.Options.MaxParallelCmds {
+		return false
+	}
+	// while the system is busy only the running commands continue (but something is always running)
+	if TaskStats.runningCmds > 0 && throttleReason() != "" {
+		return false
+	}
+	if command != task && task.Config.MaxParallel > 0 && task.runningChildren() >= task.Config.MaxParallel {
+		return false
+	}
+	for _, name := range command.Config.Uses {
+		if TaskStats.resourcesInUse[name] >= config.Options.Resources[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// startCommand runs the given command (the task command or a child task command) in the background, taking a slot of
+// every resource it uses until it completes (see releaseResources)
+func (task *Task) startCommand(command *Task, environment map[string]string) {
+	if config.Cli.PlainUI {
+		plainDisplayStart(command)
+	}
+	// the start time is only set here (before the command goroutine reads it), while the main loop reads it for the eta
+	command.Command.StartTime = time.Now()
+	command.Command.Started = true
+
+	// the command is waited on from the moment it is started (even before it has reported running)
+	task.waiter.Add(1)
+	go command.runSingleCmd(task.resultChan, &task.waiter, environment)
+	TaskStats.runningCmds++
+
+	if TaskStats.resourcesInUse == nil {
+		TaskStats.resourcesInUse = map[string]int{}
+	}
+	for _, name := range command.Config.Uses {
+		TaskStats.resourcesInUse[name]++
+	}
+}
+
+// releaseResources frees the slot of every resource used by the given (completed) command
+func releaseResources(command *Task) {
+	for _, name := range command.Config.Uses {
+		TaskStats.resourcesInUse[name]--
+	}
+}
+
+// runningChildren returns the number of child tasks that are running
+func (task *Task) runningChildren() (count int) {
+	for _, subTask := range task.Children {
+		if subTask.Command.Started && !subTask.Command.Complete {
+			count++
+		}
+	}
+	return count
+}
+
+// simulateRuntime returns the seconds until all of the given commands complete (given the runtime of each command) when
+// started in the given order within the given limit of parallel commands and the limit of each resource. Commands that
+// have already started are running (or complete) from the start, in the order given.
+func simulateRuntime(commands []*Task, runtime func(*TaskCommand) time.Duration, limit int) float64 {
+	type simulatedCommand struct {
+		task      *Task
+		endSecond float64
+	}
+	var running []simulatedCommand
+	var pending []*Task
+	inUse := map[string]int{}
+
+	start := func(task *Task, second float64) {
+		running = append(running, simulatedCommand{task: task, endSecond: second + runtime(&task.Command).Seconds()})
+		for _, name := range task.Config.Uses {
+			inUse[name]++
+		}
+	}
+	fits := func(task *Task) bool {
+		if len(running) >= limit {
+			return false
+		}
+		for _, name := range task.Config.Uses {
+			if inUse[name] >= config.Options.Resources[name] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, task := range commands {
+		if task.Command.Started {
+			start(task, 0)
+		} else {
+			pending = append(pending, task)
+		}
+	}
+
+	var currentSecond float64
+	for len(running) > 0 || len(pending) > 0 {
+		// start every pending command that fits (a command waiting on a resource does not hold back the others)
+		for idx := 0; idx < len(pending); {
+			if fits(pending[idx]) {
+				start(pending[idx], currentSecond)
+				pending = append(pending[:idx], pending[idx+1:]...)
+			} else {
+				idx++
+			}
+		}
+		if len(running) == 0 {
+			// nothing can ever start (there are no slots)
+			break
+		}
+
+		// skip ahead to when the next command completes
+		next := 0
+		for idx := range running {
+			if running[idx].endSecond < running[next].endSecond {
+				next = idx
+			}
+		}
+		currentSecond = math.Max(currentSecond, running[next].endSecond)
+		for _, name := range running[next].task.Config.Uses {
+			inUse[name]--
+		}
+		running = append(running[:next], running[next+1:]...)
+	}
+	return currentSecond
+}
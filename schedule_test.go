@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestScheduleTasks(t *testing.T) {
+	yamlStr := `
+config:
+  max-parallel-commands: 2
+  schedule: %s
+tasks:
+  - name: Group
+    parallel-tasks:
+      - name: quick
+        cmd: echo quick
+      - name: new
+        cmd: echo new
+      - name: other
+        cmd: echo other
+      - name: slow
+        cmd: echo slow
+`
+	defer func() { config.Cli = CliOptions{} }()
+	config.Cli.YamlPath = "/src/run.yml"
+
+	tests := []struct {
+		schedule string
+		order    []string
+		eta      float64
+	}{
+		// slow is started last, once quick completes (new has no eta and is started after all others when ordered by eta)
+		{scheduleFifo, []string{"quick", "new", "other", "slow"}, 35},
+		// slow runs for the whole group while the rest run alongside it
+		{scheduleLongestFirst, []string{"slow", "other", "quick", "new"}, 30},
+		{scheduleShortestFirst, []string{"quick", "other", "slow", "new"}, 35},
+	}
+	for _, test := range tests {
+		config.etaCache = newEtaCache()
+		config.etaCache.Tasks["/src/run.yml:Group/quick"] = &etaSamples{Successes: []float64{5}}
+		config.etaCache.Tasks["/src/run.yml:Group/other"] = &etaSamples{Successes: []float64{10}}
+		config.etaCache.Tasks["/src/run.yml:Group/slow"] = &etaSamples{Successes: []float64{30}}
+
+		parseRunYaml([]byte(fmt.Sprintf(yamlStr, test.schedule)))
+		tasks := CreateTasks()
+
+		var order []string
+		for _, task := range tasks[0].scheduledChildren() {
+			order = append(order, task.Config.Name)
+		}
+		if repr.String(order) != repr.String(test.order) {
+			t.Error("TestScheduleTasks: Expected the", test.schedule, "order", repr.String(test.order), "got", repr.String(order))
+		}
+		if config.totalEtaSeconds != test.eta {
+			t.Error("TestScheduleTasks: Expected the", test.schedule, "eta to be", test.eta, "got", config.totalEtaSeconds)
+		}
+	}
+}
+
+func TestParallelLimits(t *testing.T) {
+	yamlStr := `
+config:
+  max-parallel-commands: 4
+  resources:
+    db: 1
+tasks:
+  - name: Migrations
+    parallel-tasks:
+      - name: migrate <replace>
+        cmd: sleep 0.2
+        uses: db
+        for-each: [a, b]
+      - name: lint
+        cmd: sleep 0.2
+  - name: Tests
+    max-parallel: 1
+    parallel-tasks:
+      - name: first
+        cmd: sleep 0.1
+      - name: second
+        cmd: sleep 0.1
+`
+	dir, err := ioutil.TempDir("", "bashful-schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cachePathValue := config.CachePath
+	defer func() { config.CachePath = cachePathValue }()
+	config.CachePath = dir
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	scr.SetRenderer(newVirtualTerminal(80, 24))
+
+	run([]byte(yamlStr), map[string]string{})
+
+	overlap := func(first, second *Task) bool {
+		return first.Command.StartTime.Before(second.Command.StopTime) && second.Command.StartTime.Before(first.Command.StopTime)
+	}
+	migrations, tests := allTasks[0].Children, allTasks[1].Children
+	if overlap(migrations[0], migrations[1]) || !overlap(migrations[0], migrations[2]) {
+		t.Error("TestParallelLimits: Expected only the tasks using the db to run one at a time")
+	}
+	if overlap(tests[0], tests[1]) {
+		t.Error("TestParallelLimits: Expected the tests to run one at a time")
+	}
+	if TaskStats.resourcesInUse["db"] != 0 {
+		t.Error("TestParallelLimits: Expected the db to be released, got", TaskStats.resourcesInUse["db"])
+	}
+
+	// the eta follows the same limits: the migrations run one after another (lint alongside), then the tests
+	parseRunYaml([]byte(yamlStr))
+	tasks := CreateTasks()
+	for _, task := range commandTasks(tasks) {
+		task.Command.EstimatedRuntime = 10 * time.Second
+	}
+	var eta float64
+	for _, task := range tasks {
+		eta += task.EstimateRuntime()
+	}
+	if eta != 40 {
+		t.Error("TestParallelLimits: Expected the eta to follow the parallel limits, got", eta)
+	}
+}
+
+func TestResourceReplicas(t *testing.T) {
+	yamlStr := `
+config:
+  resources:
+    db-a: 1
+    db-b: 1
+tasks:
+  - name: Migrations
+    parallel-tasks:
+      - name: migrate <replace>
+        cmd: echo <replace>
+        uses: db-<replace>
+        for-each: [a, b]
+`
+	parseRunYaml([]byte(yamlStr))
+
+	var uses []string
+	for _, subTaskConfig := range config.TaskConfigs[0].ParallelTasks {
+		uses = append(uses, subTaskConfig.Uses...)
+	}
+	if repr.String(uses) != repr.String([]string{"db-a", "db-b"}) {
+		t.Error("TestResourceReplicas: Expected each replica to use its own resource, got", repr.String(uses))
+	}
+}
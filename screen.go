@@ -2,61 +2,296 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
-	"github.com/k0kubun/go-ansi"
+	"github.com/rivo/uniseg"
 	terminal "github.com/wayneashleyberry/terminal-dimensions"
 )
 
 var (
-	instance      *screen
-	once          sync.Once
-	terminalWidth = terminal.Width
+	instance       *screen
+	once           sync.Once
+	terminalWidth  = terminal.Width
+	terminalHeight = terminal.Height
+
+	// terminalResized receives a value whenever the dimensions of the attached terminal change
+	terminalResized = make(chan os.Signal, 1)
 )
 
+// renderer is an output device that screen frames are drawn to (using ansi control codes for cursor movement)
+type renderer interface {
+	io.Writer
+
+	// Width returns the number of columns available to draw a single line
+	Width() int
+
+	// Height returns the number of rows visible at once (0 indicates that the number of rows is unknown)
+	Height() int
+}
+
+// ansiTerminal is a renderer that writes directly to the stdout of the bashful process
+type ansiTerminal struct{}
+
+// Write sends the given bytes (including ansi control codes) to stdout (see plainOutput)
+func (term ansiTerminal) Write(p []byte) (int, error) {
+	if _, err := os.Stdout.Write(plainOutput(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Width returns the current width of the attached terminal
+func (term ansiTerminal) Width() int {
+	width, err := terminalWidth()
+	if err != nil || width == 0 {
+		logToMain("Unable to determine screen width", errorFormat)
+		return 80
+	}
+	return int(width)
+}
+
+// Height returns the current height of the attached terminal
+func (term ansiTerminal) Height() int {
+	height, err := terminalHeight()
+	if err != nil {
+		return 0
+	}
+	return int(height)
+}
+
+// watchTerminalResize notifies terminalResized whenever the attached terminal is resized
+func watchTerminalResize() {
+	signal.Notify(terminalResized, syscall.SIGWINCH)
+}
+
 type screen struct {
+	renderer  renderer
 	numLines  int
 	curLine   int
 	hasHeader bool
 	hasFooter bool
+
+	// rows is the message last drawn on each row of the frame (rows missing from the model are always redrawn)
+	rows map[int]string
+}
+
+// frameLimiter coalesces screen updates so that the screen frame is drawn at most once per interval (a skipped update
+// schedules a frame on the pending channel, so the latest state is always drawn eventually)
+type frameLimiter struct {
+	interval time.Duration
+	last     time.Time
+	pending  <-chan time.Time
 }
 
 // newScreen is a singleton that represents the screen frame being actively written to
 func newScreen() *screen {
 	once.Do(func() {
-		instance = &screen{}
+		instance = &screen{renderer: ansiTerminal{}, rows: make(map[int]string)}
 	})
 	return instance
 }
 
-func visualLength(str string) int {
-	inEscapeSeq := false
-	length := 0
+// SetRenderer replaces the output device that all screen frames are drawn to
+func (scr *screen) SetRenderer(out renderer) {
+	scr.renderer = out
+}
 
-	for _, r := range str {
-		switch {
-		case inEscapeSeq:
-			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
-				inEscapeSeq = false
+// ready indicates if a frame may be drawn now, otherwise a frame is scheduled on the pending channel
+func (limiter *frameLimiter) ready() bool {
+	wait := limiter.interval - time.Since(limiter.last)
+	if wait <= 0 {
+		return true
+	}
+	if limiter.pending == nil {
+		limiter.pending = time.After(wait)
+	}
+	return false
+}
+
+// drawn records that a frame has just been drawn (satisfying any scheduled frame)
+func (limiter *frameLimiter) drawn() {
+	limiter.last = time.Now()
+	limiter.pending = nil
+}
+
+// Println writes the given values as a line outside of any screen frame (e.g. preambles and reports)
+func (scr *screen) Println(values ...interface{}) {
+	scr.forgetRows()
+	fmt.Fprintln(scr.renderer, values...)
+}
+
+// Print writes the given values outside of any screen frame without a trailing newline
+func (scr *screen) Print(values ...interface{}) {
+	scr.forgetRows()
+	fmt.Fprint(scr.renderer, values...)
+}
+
+// forgetRows discards the model of what each frame row shows, so that every row is redrawn on the next update
+func (scr *screen) forgetRows() {
+	scr.rows = make(map[int]string)
+}
+
+// escapeSequenceLength returns the number of bytes used by the ansi escape sequence at the start of the given string (0
+// if the string does not start with an escape sequence). An unterminated sequence uses the remainder of the string.
+func escapeSequenceLength(str string) int {
+	if len(str) == 0 || str[0] != '\x1b' {
+		return 0
+	}
+	if len(str) == 1 {
+		return 1
+	}
+
+	switch str[1] {
+	case '[':
+		// CSI: parameter and intermediate bytes followed by a single final byte (e.g. colors and cursor movement)
+		for idx := 2; idx < len(str); idx++ {
+			if str[idx] >= 0x40 && str[idx] <= 0x7e {
+				return idx + 1
 			}
-		case r == '\x1b':
-			inEscapeSeq = true
-		default:
-			length++
 		}
+	case ']', 'P', 'X', '^', '_':
+		// OSC, DCS, SOS, PM, and APC: a string terminated by BEL or ST (e.g. window titles and hyperlinks)
+		for idx := 2; idx < len(str); idx++ {
+			if str[idx] == '\a' {
+				return idx + 1
+			}
+			if str[idx] == '\x1b' && idx+1 < len(str) && str[idx+1] == '\\' {
+				return idx + 2
+			}
+		}
+	default:
+		// any intermediate bytes followed by a single final byte (e.g. selecting a character set)
+		for idx := 1; idx < len(str); idx++ {
+			if str[idx] < 0x20 || str[idx] > 0x2f {
+				return idx + 1
+			}
+		}
+	}
+	return len(str)
+}
+
+// walkDisplayUnits calls the given function with every escape sequence (which uses no columns) and every grapheme
+// cluster (along with the number of terminal columns it uses) within the given string, in order
+func walkDisplayUnits(str string, visit func(unit string, width int, escape bool)) {
+	state := -1
+	for len(str) > 0 {
+		if length := escapeSequenceLength(str); length > 0 {
+			visit(str[:length], 0, true)
+			str = str[length:]
+			state = -1
+			continue
+		}
+
+		var cluster string
+		var width int
+		cluster, str, width, state = uniseg.FirstGraphemeClusterInString(str, state)
+		visit(cluster, width, false)
+	}
+}
+
+// sgrColorParameters is every SGR parameter that only affects the color or style of text (any others, such as blink or
+// conceal, are removed from preserved task output)
+var sgrColorParameters = regexp.MustCompile(`^(0?|1|2|3|4|7|9|2[1-9]|3[0-79]|4[0-79]|9[0-7]|10[0-7]|[34]8;5;\d{1,3}|[34]8;2;\d{1,3};\d{1,3};\d{1,3})$`)
+
+// sanitizeColors removes every escape sequence from the given string except for SGR color and style values. A color
+// left active at the end of the string is reset (so it does not bleed into whatever is drawn after it).
+func sanitizeColors(message string) string {
+	var sanitized strings.Builder
+	var colorActive bool
+	walkDisplayUnits(message, func(unit string, width int, escape bool) {
+		if !escape {
+			sanitized.WriteString(unit)
+			return
+		}
+		if !strings.HasPrefix(unit, "\x1b[") || !strings.HasSuffix(unit, "m") {
+			return
+		}
+
+		var kept []string
+		params := strings.Split(unit[2:len(unit)-1], ";")
+		for idx := 0; idx < len(params); idx++ {
+			// extended colors span several parameters (e.g. 38;5;n or 38;2;r;g;b)
+			span := 1
+			if (params[idx] == "38" || params[idx] == "48") && idx+1 < len(params) {
+				if params[idx+1] == "5" {
+					span = 3
+				} else if params[idx+1] == "2" {
+					span = 5
+				}
+			}
+			if idx+span > len(params) {
+				break
+			}
+			param := strings.Join(params[idx:idx+span], ";")
+			if sgrColorParameters.MatchString(param) {
+				kept = append(kept, param)
+			}
+			idx += span - 1
+		}
+		if len(kept) > 0 {
+			sanitized.WriteString("\x1b[" + strings.Join(kept, ";") + "m")
+			last := kept[len(kept)-1]
+			colorActive = last != "" && last != "0"
+		}
+	})
+	if colorActive {
+		sanitized.WriteString("\x1b[0m")
 	}
+	return sanitized.String()
+}
 
+// stripEscapes removes every escape sequence (including color values) from the given string
+func stripEscapes(message string) string {
+	var stripped strings.Builder
+	walkDisplayUnits(message, func(unit string, width int, escape bool) {
+		if !escape {
+			stripped.WriteString(unit)
+		}
+	})
+	return stripped.String()
+}
+
+// visualLength returns the number of terminal columns needed to show the given string (escape sequences use no columns,
+// wide characters such as CJK and emoji use two columns, and combining characters use none)
+func visualLength(str string) int {
+	length := 0
+	walkDisplayUnits(str, func(unit string, width int, escape bool) {
+		length += width
+	})
 	return length
 }
 
+// trimToVisualLength removes whole grapheme clusters from the end of the given string until it fits within the given
+// number of terminal columns (the string is never trimmed to nothing). Any color values past the cut are kept so that
+// colors are still reset.
 func trimToVisualLength(message string, length int) string {
-	for visualLength(message) > length && len(message) > 1 {
-		message = message[:len(message)-1]
-	}
-	return message
+	var trimmed strings.Builder
+	used := 0
+	cut := false
+
+	walkDisplayUnits(message, func(unit string, width int, escape bool) {
+		switch {
+		case escape && (!cut || strings.HasSuffix(unit, "m")):
+			trimmed.WriteString(unit)
+		case !escape && !cut && (used+width <= length || trimmed.Len() == 0):
+			trimmed.WriteString(unit)
+			used += width
+		default:
+			cut = true
+		}
+	})
+	return trimmed.String()
 }
 
 func (scr *screen) ResetFrame(numLines int, hasHeader, hasFooter bool) {
+	scr.forgetRows()
 	scr.curLine = 0
 	scr.numLines = numLines
 	scr.hasFooter = hasFooter
@@ -64,7 +299,7 @@ func (scr *screen) ResetFrame(numLines int, hasHeader, hasFooter bool) {
 
 	if hasHeader {
 		// note: this index doesn't count!
-		fmt.Println("")
+		scr.Println("")
 	}
 	for idx := 0; idx < numLines; idx++ {
 		scr.printLn("")
@@ -75,7 +310,8 @@ func (scr *screen) ResetFrame(numLines int, hasHeader, hasFooter bool) {
 	scr.MoveCursorToFirstLine()
 }
 
-func (scr *screen) MoveCursor(index int) {
+// frameIndex limits the given row index to the rows within the frame (including the header and footer)
+func (scr *screen) frameIndex(index int) int {
 	// move to the first possible line (first line or header) if asked to move beyond defined frame
 	if index < 0 && !scr.hasHeader {
 		index = 0
@@ -90,13 +326,18 @@ func (scr *screen) MoveCursor(index int) {
 	if index > scr.numLines && scr.hasFooter {
 		index = scr.numLines
 	}
+	return index
+}
+
+func (scr *screen) MoveCursor(index int) {
+	index = scr.frameIndex(index)
 
 	moves := scr.curLine - index
 	if moves != 0 {
 		if moves < 0 {
-			ansi.CursorDown(moves * -1)
+			scr.cursorDown(moves * -1)
 		} else {
-			ansi.CursorUp(moves)
+			scr.cursorUp(moves)
 		}
 		scr.curLine -= moves
 	}
@@ -119,13 +360,11 @@ func (scr *screen) MoveCursorToFooter() {
 }
 
 func (scr *screen) DisplayFooter(message string) {
-	scr.MoveCursorToFooter()
-	scr.printLn(message)
+	scr.drawRow(scr.numLines, message)
 }
 
 func (scr *screen) DisplayHeader(message string) {
-	scr.MoveCursorToHeader()
-	scr.printLn(message)
+	scr.drawRow(-1, message)
 }
 
 func (scr *screen) EraseBelowHeader() {
@@ -140,34 +379,86 @@ func (scr *screen) EraseBelowHeader() {
 	}
 }
 
+// ResizeFrame grows or shrinks the number of lines within the current frame (the footer, if any, must be redrawn afterwards)
+func (scr *screen) ResizeFrame(numLines int) {
+	lastLine := scr.numLines - 1
+	if scr.hasFooter {
+		lastLine = scr.numLines
+	}
+
+	if numLines > scr.numLines {
+		// append new rows below the last row of the frame (this may scroll the terminal)
+		scr.MoveCursor(lastLine)
+		fmt.Fprint(scr.renderer, strings.Repeat("\n", numLines-scr.numLines))
+		scr.curLine += numLines - scr.numLines
+	} else if numLines < scr.numLines {
+		// erase all rows that are no longer part of the frame
+		scr.MoveCursor(numLines)
+		fmt.Fprint(scr.renderer, "\x1b[0G\x1b[0J")
+	}
+
+	// the content of new rows (and the footer row) is unknown
+	for index := range scr.rows {
+		if index > lastLine || index >= numLines {
+			delete(scr.rows, index)
+		}
+	}
+	scr.numLines = numLines
+}
+
+// ClearFrame erases the entire screen frame (including the header and footer) and places the cursor where the frame began
+func (scr *screen) ClearFrame() {
+	if scr.hasHeader {
+		scr.MoveCursorToHeader()
+		scr.curLine = 0
+	} else {
+		scr.MoveCursorToFirstLine()
+	}
+	fmt.Fprint(scr.renderer, "\x1b[0G\x1b[0J")
+	scr.forgetRows()
+}
+
 func (scr *screen) MovePastFrame(keepFooter bool) {
 	scr.MoveCursorToFooter()
 	if scr.hasFooter && keepFooter || !scr.hasFooter {
-		ansi.CursorDown(1)
+		scr.cursorDown(1)
 		scr.curLine++
 	}
 }
 
 func (scr *screen) Display(message string, index int) {
-	scr.MoveCursor(index)
-
 	// trim message length if it won't fit on the screen
-	width, err := terminalWidth()
-	if err != nil {
-		logToMain("Unable to determine screen width", errorFormat)
-		width = 80
-	}
-	for visualLength(message) > int(width) {
-		message = trimToVisualLength(message, int(width)-3) + "..."
+	width := scr.renderer.Width()
+	if visualLength(message) > width {
+		message = trimToVisualLength(message, width-3) + "..."
 	}
 
+	scr.drawRow(index, message)
+}
+
+// drawRow writes the given message on the given row of the frame, unless the row already shows the exact same message
+func (scr *screen) drawRow(index int, message string) {
+	index = scr.frameIndex(index)
+	if drawn, ok := scr.rows[index]; ok && drawn == message {
+		return
+	}
+	scr.MoveCursor(index)
 	scr.printLn(message)
 }
 
+func (scr *screen) cursorUp(lines int) {
+	fmt.Fprintf(scr.renderer, "\x1b[%dA", lines)
+}
+
+func (scr *screen) cursorDown(lines int) {
+	fmt.Fprintf(scr.renderer, "\x1b[%dB", lines)
+}
+
 func (scr *screen) printLn(message string) {
-	ansi.EraseInLine(2)
-	ansi.CursorHorizontalAbsolute(0)
+	// erase the entire line and move to the first column
+	fmt.Fprint(scr.renderer, "\x1b[2K\x1b[0G")
 	// note: ansi cursor down cannot be used as this may be the last row
-	fmt.Println(message)
+	fmt.Fprintln(scr.renderer, message)
+	scr.rows[scr.curLine] = message
 	scr.curLine++
 }
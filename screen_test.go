@@ -2,13 +2,54 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/alecthomas/repr"
 )
 
+var updateGolden = flag.Bool("update", false, "update the golden screen snapshots in testdata/")
+
+// volatileReportValues matches the failure report values that are replaced before comparing snapshots
+var volatileReportValues = regexp.MustCompile(`(─ (duration|working dir): )[^\n]*`)
+
+// volatileProgress matches the progress of a run in progress (this is based on the elapsed time of running tasks)
+var volatileProgress = regexp.MustCompile(`[0-9.]+% Complete`)
+
+// assertGoldenScreen compares the given virtual terminal contents with the snapshot stored in testdata/<name>.golden
+func assertGoldenScreen(t *testing.T, name string, term *virtualTerminal) {
+	// the failure report shows values that differ from run to run
+	assertGolden(t, name, volatileReportValues.ReplaceAllString(term.String(), "$1<$2>"))
+}
+
+// assertGolden compares the given screen contents with the snapshot stored in testdata/<name>.golden
+func assertGolden(t *testing.T, name, actual string) {
+	goldenPath := filepath.Join("testdata", name+".golden")
+
+	if *updateGolden {
+		os.MkdirAll("testdata", 0755)
+		if err := ioutil.WriteFile(goldenPath, []byte(actual), 0644); err != nil {
+			t.Fatal("Unable to update golden file:", err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal("Unable to read golden file:", err)
+	}
+	if string(expected) != actual {
+		t.Error(name+": Expected screen:\n", string(expected), "\ngot screen:\n", actual)
+	}
+}
+
 func captureBoolStdout(f func(bool), x bool) string {
 	old := os.Stdout
 	r, w, _ := os.Pipe()
@@ -94,6 +135,176 @@ func TestTrimToVisualLength(t *testing.T) {
 	}
 }
 
+func TestUnicodeVisualLength(t *testing.T) {
+	var testData = []struct {
+		name     string
+		input    string
+		expected int
+	}{
+		{"cjk", "日本語", 6},
+		{"emoji", "build 🚀", 8},
+		{"zwj emoji", "👩‍💻", 2},
+		{"flag", "🇯🇵", 2},
+		{"combining", "e\u0301te\u0301", 3},
+		{"osc hyperlink", "\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\", 4},
+		{"osc title with bel", "\x1b]0;title\atext", 4},
+		{"csi private mode", "\x1b[?25lab\x1b[?25h", 2},
+		{"charset selection", "\x1b(Bab", 2},
+	}
+
+	for _, testObj := range testData {
+		actual := visualLength(testObj.input)
+		if actual != testObj.expected {
+			t.Error("TestUnicodeVisualLength (", testObj.name, "): Expected", testObj.expected, "got", actual)
+		}
+	}
+}
+
+func TestUnicodeTrimToVisualLength(t *testing.T) {
+	var testData = []struct {
+		name     string
+		input    string
+		length   int
+		expected string
+	}{
+		{"cjk on a boundary", "日本語", 4, "日本"},
+		{"cjk within a character", "日本語", 5, "日本"},
+		{"zwj emoji", "a👩‍💻b", 2, "a"},
+		{"combining", "e\u0301te\u0301", 3, "e\u0301te\u0301"},
+		{"combining cut", "e\u0301te\u0301", 2, "e\u0301t"},
+		{"colors kept past the cut", "\x1b[31m日本語\x1b[0m", 2, "\x1b[31m日\x1b[0m"},
+	}
+
+	for _, testObj := range testData {
+		actual := trimToVisualLength(testObj.input, testObj.length)
+		if actual != testObj.expected {
+			t.Error("TestUnicodeTrimToVisualLength (", testObj.name, "): Expected", repr.String(testObj.expected), "got", repr.String(actual))
+		}
+		if !utf8.ValidString(actual) {
+			t.Error("TestUnicodeTrimToVisualLength (", testObj.name, "): Expected valid utf-8, got", repr.String(actual))
+		}
+	}
+}
+
+func TestSanitizeColors(t *testing.T) {
+	var testData = []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"basic colors", "\x1b[31mred\x1b[0m", "\x1b[31mred\x1b[0m"},
+		{"reset without params", "\x1b[1mbold\x1b[m", "\x1b[1mbold\x1b[m"},
+		{"extended colors", "\x1b[38;5;208;48;2;1;2;3mx", "\x1b[38;5;208;48;2;1;2;3mx\x1b[0m"},
+		{"blink removed", "\x1b[5;32mok", "\x1b[32mok\x1b[0m"},
+		{"unclosed color reset", "\x1b[31merror", "\x1b[31merror\x1b[0m"},
+		{"color after reset", "\x1b[0;1mbold", "\x1b[0;1mbold\x1b[0m"},
+		{"only unsafe params", "\x1b[8mhidden", "hidden"},
+		{"cursor movement removed", "a\x1b[2Ab\x1b[Kc", "abc"},
+		{"osc removed", "\x1b]0;title\x07text", "text"},
+		{"incomplete extended color", "\x1b[38;5mx", "x"},
+	}
+
+	for _, testObj := range testData {
+		actual := sanitizeColors(testObj.input)
+		if actual != testObj.expected {
+			t.Error("TestSanitizeColors (", testObj.name, "): Expected", repr.String(testObj.expected), "got", repr.String(actual))
+		}
+	}
+
+	// a line of preserved task output never leaves a color active for the rest of the row (e.g. the eta)
+	task := NewTask(TaskConfig{Name: "colors", CmdString: "/bin/true", PreserveColors: true}, 1, "")
+	if actual := task.cleanOutput("\x1b[31merror: failed"); !strings.HasSuffix(actual, "\x1b[0m") {
+		t.Error("TestSanitizeColors: Expected a preserved-color line to end reset, got", repr.String(actual))
+	}
+}
+
+func TestDisplayOnlyChangedRows(t *testing.T) {
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+
+	term := &recordingTerminal{virtualTerminal: newVirtualTerminal(40, 10)}
+	scr.SetRenderer(term)
+	scr.ResetFrame(2, false, true)
+
+	scr.Display("first", 0)
+	scr.Display("second", 1)
+	scr.DisplayFooter("footer")
+	term.raw.Reset()
+
+	// redrawing the same content should not write anything
+	scr.Display("first", 0)
+	scr.Display("second", 1)
+	scr.DisplayFooter("footer")
+	if term.raw.Len() != 0 {
+		t.Error("TestDisplayOnlyChangedRows: Expected no output for unchanged rows, got", repr.String(term.raw.String()))
+	}
+
+	// only the changed row should be rewritten
+	scr.Display("first", 0)
+	scr.Display("changed", 1)
+	if strings.Contains(term.raw.String(), "first") || !strings.Contains(term.raw.String(), "changed") {
+		t.Error("TestDisplayOnlyChangedRows: Expected only the changed row to be written, got", repr.String(term.raw.String()))
+	}
+
+	// any output outside of the frame invalidates the model of the frame
+	scr.Print("")
+	term.raw.Reset()
+	scr.Display("first", 0)
+	if !strings.Contains(term.raw.String(), "first") {
+		t.Error("TestDisplayOnlyChangedRows: Expected the row to be redrawn, got", repr.String(term.raw.String()))
+	}
+
+	expected := "first\nchanged\nfooter\n"
+	if term.String() != expected {
+		t.Error("TestDisplayOnlyChangedRows: Expected", repr.String(expected), "got", repr.String(term.String()))
+	}
+}
+
+func TestFrameLimiter(t *testing.T) {
+	limiter := frameLimiter{interval: 20 * time.Millisecond}
+	if !limiter.ready() {
+		t.Error("TestFrameLimiter: Expected the first frame to be ready")
+	}
+
+	limiter.drawn()
+	if limiter.ready() || limiter.pending == nil {
+		t.Error("TestFrameLimiter: Expected the next frame to be scheduled")
+	}
+
+	<-limiter.pending
+	if !limiter.ready() {
+		t.Error("TestFrameLimiter: Expected a frame to be ready after the interval")
+	}
+}
+
+func TestFrameCoalescing(t *testing.T) {
+	yamlStr := `
+config:
+  show-summary-times: false
+  frame-rate: 10
+tasks:
+  - name: Noisy
+    parallel-tasks:
+      - cmd: seq 1 3000
+      - cmd: seq 1 3000
+`
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+
+	term := &recordingTerminal{virtualTerminal: newVirtualTerminal(80, 24)}
+	scr.SetRenderer(term)
+	run([]byte(yamlStr), map[string]string{})
+
+	// every output line is an event, but only a handful of frames (each a few rows) should be drawn
+	rowsDrawn := strings.Count(term.raw.String(), "\x1b[2K")
+	if rowsDrawn > 100 {
+		t.Error("TestFrameCoalescing: Expected output events to be coalesced, got", rowsDrawn, "rows drawn")
+	}
+	if !strings.Contains(term.String(), "100.00% Complete") {
+		t.Error("TestFrameCoalescing: Expected the final frame to be drawn, got", repr.String(term.String()))
+	}
+}
+
 func TestMoveCursor(t *testing.T) {
 	var expectedOutput, testOutput string
 	scr := newScreen()
@@ -258,3 +469,180 @@ func TestDisplay(t *testing.T) {
 	}
 
 }
+
+func TestRunSnapshots(t *testing.T) {
+	var testData = []struct {
+		name string
+		yaml string
+	}{
+		{"serial", `
+config:
+  show-summary-times: false
+tasks:
+  - name: first
+    cmd: echo one
+  - name: second
+    cmd: echo two
+`},
+		{"parallel-with-errors", `
+config:
+  show-summary-times: false
+  show-summary-errors: true
+  stop-on-failure: false
+tasks:
+  - name: Compiling
+    parallel-tasks:
+      - name: compile a
+        cmd: echo a
+      - name: compile b
+        cmd: echo "b is broken" >&2; exit 3
+      - name: compile c
+        cmd: echo c
+  - name: after
+    cmd: "true"
+`},
+		{"collapsed", `
+config:
+  show-summary-times: false
+  collapse-on-completion: true
+tasks:
+  - name: Hidden group
+    parallel-tasks:
+      - cmd: echo a
+      - cmd: echo b
+  - name: visible
+    cmd: "true"
+`},
+		{"themed", `
+config:
+  show-summary-times: false
+  stop-on-failure: false
+theme:
+  monochrome: true
+  branch-glyph: "|-"
+  last-branch-glyph: "'-"
+  title-width: 12
+  line-template: '{{.Status}}{{symbol .Symbol}}{{reset}}{{branch}}{{title .Title}}: {{.Msg}}{{.Split}}{{.Eta}}'
+tasks:
+  - name: Themed
+    parallel-tasks:
+      - name: passes
+        cmd: "true"
+      - name: fails
+        cmd: exit 1
+`},
+		{"wide-names", `
+config:
+  show-summary-times: false
+  show-failure-report: false
+  stop-on-failure: false
+tasks:
+  - name: ビルド
+    parallel-tasks:
+      - name: 🚀 launch
+        cmd: exit 1
+      - name: 検査
+        cmd: exit 2
+      - name: check
+        cmd: exit 3
+  - name: 完了 ✅
+    cmd: echo 終わり
+`},
+	}
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+
+	for _, testObj := range testData {
+		term := newVirtualTerminal(80, 24)
+		scr.SetRenderer(term)
+
+		run([]byte(testObj.yaml), map[string]string{})
+		assertGoldenScreen(t, testObj.name, term)
+	}
+}
+
+func TestOutputTailSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bashful-tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the task keeps running (showing the tail of its output) until the snapshot is taken
+	release := filepath.Join(dir, "release")
+	yamlStr := `
+config:
+  show-summary-times: false
+  output-lines: 3
+theme:
+  spinner: "*"
+tasks:
+  - name: Printing
+    cmd: for i in 1 2 3 4 5; do echo line $i; done; while [ ! -f ` + release + ` ]; do sleep 0.01; done
+  - name: after
+    cmd: echo done
+`
+	cachePathValue := config.CachePath
+	defer func() {
+		config.CachePath = cachePathValue
+	}()
+	config.CachePath = dir
+
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	term := newVirtualTerminal(80, 24)
+	scr.SetRenderer(term)
+
+	done := make(chan bool)
+	go func() {
+		run([]byte(yamlStr), map[string]string{})
+		close(done)
+	}()
+
+	for start := time.Now(); !strings.Contains(term.String(), "line 3\n      line 4\n      line 5\n") && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertGolden(t, "output-tail", volatileProgress.ReplaceAllString(term.String(), "<progress>% Complete"))
+
+	if err := ioutil.WriteFile(release, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestViewportSnapshots(t *testing.T) {
+	yamlStr := `
+config:
+  show-summary-times: false
+  stop-on-failure: false
+  max-parallel-commands: 2
+tasks:
+  - name: Many tasks
+    parallel-tasks:
+      - name: "task <replace>"
+        cmd: case <replace> in fail*) exit 3;; esac
+        for-each: [a, b, fail-c, d, e, f, g, fail-h, i, j, k, l]
+`
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+
+	// a finished group shows failed tasks first and summarizes the rest
+	term := newVirtualTerminal(80, 8)
+	scr.SetRenderer(term)
+	run([]byte(yamlStr), map[string]string{})
+	assertGoldenScreen(t, "viewport", term)
+
+	// a pending group shows the first tasks to be run and is redrawn to fit a resized terminal
+	term = newVirtualTerminal(80, 10)
+	scr.SetRenderer(term)
+	TaskStats.completedTasks, TaskStats.totalTasks = 0, 0
+	parseRunYaml([]byte(yamlStr))
+	tasks := CreateTasks()
+	allTasks = tasks
+	tasks[0].Pave()
+	term.Resize(60, 7)
+	tasks[0].repaint()
+	scr.MovePastFrame(false)
+	assertGoldenScreen(t, "viewport-resized", term)
+}
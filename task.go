@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
 	"os"
 	"os/exec"
 	"strconv"
@@ -15,28 +14,40 @@ import (
 	"syscall"
 	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/lunixbochs/vtclean"
 	color "github.com/mgutz/ansi"
 	"github.com/tj/go-spin"
-	terminal "github.com/wayneashleyberry/terminal-dimensions"
 )
 
 var (
-	// spinner generates the spin icon character in front of running tasks
+	// spinner generates the spin icon character in front of running tasks (the frames are set by the theme)
 	spinner = spin.New()
 
 	// nextDisplayIdx is the next available screen row to use based off of the task / sub-task order.
 	nextDisplayIdx = 0
 
+	// maxCapturedLines is the most number of output lines kept in memory for a single task (older lines are discarded)
+	maxCapturedLines = 10000
+
 	// lineDefaultTemplate is the string template used to display the status values of a single task with no children
-	lineDefaultTemplate, _ = template.New("default line").Parse(` {{.Status}}  ` + color.Reset + ` {{printf "%1s" .Prefix}} {{printf "%-25s" .Title}} {{.Msg}}{{.Split}}{{.Eta}}`)
+	lineDefaultTemplate, _ = theme.parseTemplate("default line", defaultLineTemplate)
 
 	// lineParallelTemplate is the string template used to display the status values of a task that is the child of another task
-	lineParallelTemplate, _ = template.New("parallel line").Parse(` {{.Status}}  ` + color.Reset + ` {{printf "%1s" .Prefix}} ├─ {{printf "%-25s" .Title}} {{.Msg}}{{.Split}}{{.Eta}}`)
+	lineParallelTemplate, _ = theme.parseTemplate("parallel line", defaultLineTemplate)
 
 	// lineLastParallelTemplate is the string template used to display the status values of a task that is the LAST child of another task
-	lineLastParallelTemplate, _ = template.New("last parallel line").Parse(` {{.Status}}  ` + color.Reset + ` {{printf "%1s" .Prefix}} └─ {{printf "%-25s" .Title}} {{.Msg}}{{.Split}}{{.Eta}}`)
+	lineLastParallelTemplate, _ = theme.parseTemplate("last parallel line", defaultLineTemplate)
+
+	// lineTailTemplate is the string template used to display a single line of output below a running task with no parent
+	lineTailTemplate, _ = theme.parseTemplate("tail line", outputTailTemplate)
+
+	// lineParallelTailTemplate is the string template used to display a single line of output below a running task that is the child of another task
+	lineParallelTailTemplate, _ = theme.parseTemplate("parallel tail line", outputTailTemplate)
+
+	// lineLastParallelTailTemplate is the string template used to display a single line of output below a running task that is the LAST child of another task
+	lineLastParallelTailTemplate, _ = theme.parseTemplate("last parallel tail line", outputTailTemplate)
 )
 
 // TaskStats is a global struct keeping track of the number of running tasks, failed tasks, completed tasks, and total tasks
@@ -52,6 +63,9 @@ var TaskStats struct {
 
 	// totalTasks is the number of tasks that is expected to be run based on the user configuration
 	totalTasks int
+
+	// resourcesInUse indicates the number of actively running tasks using each named resource (see the 'uses' task option)
+	resourcesInUse map[string]int
 }
 
 // Task is a runtime object derived from the TaskConfig (parsed from the user yaml) and contains everything needed to execute, track, and display the task.
@@ -71,14 +85,18 @@ type Task struct {
 	// LogFile is the temporary log file where all formatted stdout/stderr events are recorded
 	LogFile *os.File
 
+	// logWritten is closed once every entry of the task log has been written (and the log file is closed). This is nil
+	// until the log file is created.
+	logWritten chan bool
+
 	// ErrorBuffer contains all stderr lines generated from the executed command (used to generate the task report)
 	ErrorBuffer *bytes.Buffer
 
 	// Children is a list of all sub-tasks that should be run concurrently
 	Children []*Task
 
-	// lastStartedTask is the index of the last child task that was started
-	lastStartedTask int
+	// startOrder is the order the child tasks are started in (see scheduledChildren)
+	startOrder []*Task
 
 	// resultChan is a channel where all raw command events are queued to
 	resultChan chan CmdEvent
@@ -91,6 +109,32 @@ type Task struct {
 
 	// failedTasks is a list of tasks with a non-zero return value
 	failedTasks []*Task
+
+	// outputTail is a rolling list of the most recent stdout/stderr lines (only kept when output-lines is configured)
+	outputTail []string
+
+	// capturedOutput is every stdout/stderr line (in the order received) up to maxCapturedLines
+	capturedOutput []string
+
+	// outputLock guards outputTail and capturedOutput, which may be written from the command goroutine
+	outputLock sync.Mutex
+
+	// process is the running command process (nil until the command has started successfully)
+	process *os.Process
+
+	// pendingSignal is sent to the command process as soon as it starts (a cancel or kill that arrived before then)
+	pendingSignal syscall.Signal
+
+	// processLock guards process and pendingSignal, which are set from the command goroutine (and Command.Cancelled,
+	// which is read from the command goroutine, see isCancelled)
+	processLock sync.Mutex
+
+	// parent is the task that this task is a child of (nil for a top-level task)
+	parent *Task
+
+	// groupStartTime and groupStopTime bound the execution of the task command along with all child task commands
+	groupStartTime time.Time
+	groupStopTime  time.Time
 }
 
 // TaskDisplay represents all non-config items that control how the task line should be printed to the screen
@@ -103,6 +147,12 @@ type TaskDisplay struct {
 
 	// Values holds all template values that represent the task status
 	Values LineInfo
+
+	// TailRows is the number of rows below the status line used to show a rolling tail of the task output
+	TailRows int
+
+	// ViewportRows is the number of screen rows available to a group of tasks that is taller than the terminal (0 indicates that every task has its own row)
+	ViewportRows int
 }
 
 // TaskCommand represents all non-config items used to execute and track task progress
@@ -122,6 +172,10 @@ type TaskCommand struct {
 	// EstimatedRuntime indicates the expected runtime for the given command (based off of cached values from previous runs)
 	EstimatedRuntime time.Duration
 
+	// EstimatedRuntimeLow and EstimatedRuntimeHigh bound the expected runtime for the given command (the spread of previous runs)
+	EstimatedRuntimeLow  time.Duration
+	EstimatedRuntimeHigh time.Duration
+
 	// Started indicates whether the Cmd has been attempted to run
 	Started bool
 
@@ -131,11 +185,17 @@ type TaskCommand struct {
 	// ReturnCode is simply the value returned from the child process after Cmd execution
 	ReturnCode int
 
+	// Cancelled indicates that the Cmd was stopped by the user while running (from the interactive mode)
+	Cancelled bool
+
 	// EnvReadFile is an extra pipe given to the child shell process for exfiltrating env vars back up to bashful (to provide as input for future tasks)
 	EnvReadFile *os.File
 
 	// Environment is a list of env vars from the exited child process
 	Environment map[string]string
+
+	// EnvironmentDelta lists the env vars that the command added (+), changed (~), or removed (-) (shown on the failure report)
+	EnvironmentDelta []string
 }
 
 // CommandStatus represents whether a task command is about to run, already running, or has completed (in which case, was it successful or not)
@@ -193,6 +253,9 @@ type LineInfo struct {
 	// Status is the current pending/running/error/success status of the command
 	Status string
 
+	// Symbol is the themed symbol drawn within the (colored) status column
+	Symbol string
+
 	// Title is the display name to use for the task
 	Title string
 
@@ -219,6 +282,7 @@ func NewTask(taskConfig TaskConfig, displayStartIdx int, replicaValue string) *T
 
 		subTask := NewTask(*subTaskConfig, nextDisplayIdx, replicaValue)
 		subTask.Display.Template = lineParallelTemplate
+		subTask.parent = &task
 		task.Children = append(task.Children, subTask)
 		nextDisplayIdx++
 	}
@@ -236,6 +300,13 @@ func (task *Task) inflate(displayIdx int, replicaValue string) {
 		TaskStats.totalTasks++
 	}
 
+	// the runtime is looked up once (the command may be rewritten later, e.g. once a url is downloaded)
+	task.Command.EstimatedRuntime = time.Duration(-1)
+	if samples := config.etaCache.lookup(task.Config.etaKey(), task.Config.CmdString); samples != nil {
+		if eta, low, high, known := samples.estimate(); known {
+			task.Command.EstimatedRuntime, task.Command.EstimatedRuntimeLow, task.Command.EstimatedRuntimeHigh = eta, low, high
+		}
+	}
 	task.inflateCmd()
 
 	task.Display.Template = lineDefaultTemplate
@@ -247,12 +318,6 @@ func (task *Task) inflate(displayIdx int, replicaValue string) {
 }
 
 func (task *Task) inflateCmd() {
-	if eta, ok := config.commandTimeCache[task.Config.CmdString]; ok {
-		task.Command.EstimatedRuntime = eta
-	} else {
-		task.Command.EstimatedRuntime = time.Duration(-1)
-	}
-
 	shell := os.Getenv("SHELL")
 	if len(shell) == 0 {
 		shell = "sh"
@@ -292,31 +357,75 @@ func (task *Task) updateExec(execpath string) {
 // Kill will stop any running command (including child tasks) with a -9 signal
 func (task *Task) Kill() {
 	if task.Config.CmdString != "" && task.Command.Started && !task.Command.Complete {
-		syscall.Kill(-task.Command.Cmd.Process.Pid, syscall.SIGKILL)
+		task.signal(syscall.SIGKILL)
 	}
 
 	for _, subTask := range task.Children {
 		if subTask.Config.CmdString != "" && subTask.Command.Started && !subTask.Command.Complete {
-			syscall.Kill(-subTask.Command.Cmd.Process.Pid, syscall.SIGKILL)
+			subTask.signal(syscall.SIGKILL)
 		}
 	}
 
 }
 
+// signal sends the given signal to the command process group. A command that is about to start (it was started from
+// the main loop, but the command goroutine has yet to run it) receives the signal as soon as it starts.
+func (task *Task) signal(sig syscall.Signal) {
+	task.processLock.Lock()
+	defer task.processLock.Unlock()
+	if task.process == nil {
+		task.pendingSignal = sig
+		return
+	}
+	syscall.Kill(-task.process.Pid, sig)
+}
+
+// Cancel stops the running command of this task only (along with any processes it started), leaving all other tasks running
+func (task *Task) Cancel() {
+	if task.Config.CmdString != "" && task.Command.Started && !task.Command.Complete {
+		task.processLock.Lock()
+		task.Command.Cancelled = true
+		task.processLock.Unlock()
+		task.signal(syscall.SIGTERM)
+	}
+}
+
+// isCancelled indicates that the command was cancelled (safe to call from the command goroutine)
+func (task *Task) isCancelled() bool {
+	task.processLock.Lock()
+	defer task.processLock.Unlock()
+	return task.Command.Cancelled
+}
+
+// hasUnstartedTasks indicates if the task command or any child task commands have yet to be started
+func (task *Task) hasUnstartedTasks() bool {
+	if task.Config.CmdString != "" && !task.Command.Started {
+		return true
+	}
+	for _, subTask := range task.Children {
+		if !subTask.Command.Started {
+			return true
+		}
+	}
+	return false
+}
+
 // String represents the task status and command output in a single line
 func (task *Task) String(terminalWidth int) string {
 
 	if task.Command.Complete {
 		task.Display.Values.Eta = ""
-		if task.Command.ReturnCode != 0 && !task.Config.IgnoreFailure {
+		if task.Command.Cancelled {
+			task.Display.Values.Msg = red("Cancelled")
+		} else if task.Command.ReturnCode != 0 && !task.Config.IgnoreFailure {
 			task.Display.Values.Msg = red("Exited with error (" + strconv.Itoa(task.Command.ReturnCode) + ")")
 		}
 	}
 
 	// set the name
 	if task.Config.Name == "" {
-		if len(task.Config.CmdString) > 25 {
-			task.Config.Name = task.Config.CmdString[:22] + "..."
+		if visualLength(task.Config.CmdString) > theme.TitleWidth {
+			task.Config.Name = trimToVisualLength(task.Config.CmdString, theme.TitleWidth-3) + "..."
 		} else {
 			task.Config.Name = task.Config.CmdString
 		}
@@ -363,14 +472,14 @@ func (task *Task) String(terminalWidth int) string {
 
 // display prints the current task string status to the screen
 func (task *Task) display() {
-	terminalWidth, _ := terminal.Width()
 	theScreen := newScreen()
+	terminalWidth := theScreen.renderer.Width()
 	if config.Options.SingleLineDisplay {
 
 		var durString, etaString, stepString, errorString string
 		displayString := ""
 
-		effectiveWidth := int(terminalWidth)
+		effectiveWidth := terminalWidth
 
 		fillColor := color.ColorCode(strconv.Itoa(config.Options.ColorSuccess) + "+i")
 		emptyColor := color.ColorCode(strconv.Itoa(config.Options.ColorSuccess))
@@ -379,15 +488,13 @@ func (task *Task) display() {
 			emptyColor = color.ColorCode(strconv.Itoa(config.Options.ColorError))
 		}
 
-		numFill := int(effectiveWidth) * TaskStats.completedTasks / TaskStats.totalTasks
+		numFill := int(float64(effectiveWidth) * progressPercent() / 100)
 
 		if config.Options.ShowSummaryTimes {
 			duration := time.Since(startTime)
 			durString = fmt.Sprintf(" Runtime[%s]", showDuration(duration))
 
-			totalEta := time.Duration(config.totalEtaSeconds) * time.Second
-			remainingEta := time.Duration(totalEta.Seconds()-duration.Seconds()) * time.Second
-			etaString = fmt.Sprintf(" ETA[%s]", showDuration(remainingEta))
+			etaString = fmt.Sprintf(" ETA[%s]", remainingEta())
 		}
 
 		if TaskStats.completedTasks == TaskStats.totalTasks {
@@ -405,54 +512,148 @@ func (task *Task) display() {
 		valueStr := stepString + errorString + durString + etaString
 
 		displayString = fmt.Sprintf("%[1]*s", -effectiveWidth, fmt.Sprintf("%[1]*s", (effectiveWidth+len(valueStr))/2, valueStr))
-		displayString = fillColor + displayString[:numFill] + color.Reset + emptyColor + displayString[numFill:] + color.Reset
+		displayString = fillColor + displayString[:numFill] + resetColor() + emptyColor + displayString[numFill:] + resetColor()
 
 		theScreen.Display(displayString, 0)
 	} else {
-		theScreen.Display(task.String(int(terminalWidth)), task.Display.Index)
+		theScreen.Display(task.markFocus(task.String(terminalWidth)), task.Display.Index)
+		task.displayTail(task.Display.TailRows)
 	}
 
 }
 
+// appendTail records the given output line to the rolling tail of output shown below the running task
+func (task *Task) appendTail(message string) {
+	if task.Config.OutputLines <= 0 {
+		return
+	}
+	task.outputLock.Lock()
+	defer task.outputLock.Unlock()
+
+	task.outputTail = append(task.outputTail, message)
+	if len(task.outputTail) > task.Config.OutputLines {
+		task.outputTail = task.outputTail[len(task.outputTail)-task.Config.OutputLines:]
+	}
+}
+
+// captureOutput records the given output line to the list of all output lines of the task
+func (task *Task) captureOutput(message string) {
+	task.outputLock.Lock()
+	defer task.outputLock.Unlock()
+
+	task.capturedOutput = append(task.capturedOutput, message)
+	if len(task.capturedOutput) > maxCapturedLines {
+		task.capturedOutput = task.capturedOutput[len(task.capturedOutput)-maxCapturedLines:]
+	}
+}
+
+// CapturedOutput returns a copy of all output lines captured from the task command thus far
+func (task *Task) CapturedOutput() []string {
+	task.outputLock.Lock()
+	defer task.outputLock.Unlock()
+
+	return append([]string{}, task.capturedOutput...)
+}
+
+// cleanOutput removes all cursor movement and other control sequences from a single line of command output (color values
+// are kept, after being sanitized, only if the task preserves colors and colors are not disabled)
+func (task *Task) cleanOutput(message string) string {
+	if task.Config.PreserveColors && !colorsDisabled {
+		return sanitizeColors(vtclean.Clean(message, true))
+	}
+	return vtclean.Clean(message, false)
+}
+
+// colorOutput paints a line of command output with the given color (unless the line has preserved colors of its own)
+func colorOutput(message string, paint func(string) string) string {
+	if strings.Contains(message, "\x1b[") {
+		return message
+	}
+	return paint(message)
+}
+
+// rowHeight returns the number of screen rows used by the task (a running task may show a tail of its output below the status line)
+func (task *Task) rowHeight(withTail bool) int {
+	if withTail && task.Config.OutputLines > 0 && task.Config.ShowTaskOutput && task.Command.Started && !task.Command.Complete {
+		return 1 + task.Config.OutputLines
+	}
+	return 1
+}
+
+// displayTail prints the most recent output lines on the given number of rows below the task status line
+func (task *Task) displayTail(rows int) {
+	if rows <= 0 {
+		return
+	}
+	scr := newScreen()
+
+	tailTemplate := lineTailTemplate
+	if task.Display.Template == lineParallelTemplate {
+		tailTemplate = lineParallelTailTemplate
+	} else if task.Display.Template == lineLastParallelTemplate {
+		tailTemplate = lineLastParallelTailTemplate
+	}
+
+	task.outputLock.Lock()
+	lines := make([]string, rows)
+	copy(lines[rows-len(task.outputTail):], task.outputTail)
+	task.outputLock.Unlock()
+
+	for idx, line := range lines {
+		var message bytes.Buffer
+		tailTemplate.Execute(&message, LineInfo{Status: task.Display.Values.Status, Msg: line})
+		scr.Display(message.String(), task.Display.Index+1+idx)
+	}
+}
+
 // EstimateRuntime returns the ETA in seconds until command completion
 func (task *Task) EstimateRuntime() float64 {
+	return task.estimateRuntime(func(command *TaskCommand) time.Duration { return command.EstimatedRuntime })
+}
+
+// EstimateRuntimeRange returns the shortest and longest ETA in seconds until command completion
+func (task *Task) EstimateRuntimeRange() (float64, float64) {
+	low := task.estimateRuntime(func(command *TaskCommand) time.Duration { return command.EstimatedRuntimeLow })
+	high := task.estimateRuntime(func(command *TaskCommand) time.Duration { return command.EstimatedRuntimeHigh })
+	return low, high
+}
+
+// RemainingRuntime returns the ETA in seconds (along with the shortest and longest ETA) until the rest of the command
+// completes, given what is already complete and how long running commands have been running thus far
+func (task *Task) RemainingRuntime() (float64, float64, float64) {
+	remaining := func(runtime func(*TaskCommand) time.Duration) float64 {
+		return task.estimateRuntime(func(command *TaskCommand) time.Duration {
+			return remainingRuntime(command, runtime(command))
+		})
+	}
+	eta := remaining(func(command *TaskCommand) time.Duration { return command.EstimatedRuntime })
+	low := remaining(func(command *TaskCommand) time.Duration { return command.EstimatedRuntimeLow })
+	high := remaining(func(command *TaskCommand) time.Duration { return command.EstimatedRuntimeHigh })
+	return eta, low, high
+}
+
+// estimateRuntime returns the ETA in seconds until command completion, given the runtime of each command (of the
+// commands with a known runtime)
+func (task *Task) estimateRuntime(runtime func(*TaskCommand) time.Duration) float64 {
 	var etaSeconds float64
 	// finalize task by appending to the set of final tasks
 	if task.Config.CmdString != "" && task.Command.EstimatedRuntime != -1 {
-		etaSeconds += task.Command.EstimatedRuntime.Seconds()
+		etaSeconds += runtime(&task.Command).Seconds()
 	}
 
-	var maxParallelEstimatedRuntime float64
-	var taskEndSecond []float64
-	var currentSecond float64
-	var remainingParallelTasks = config.Options.MaxParallelCmds
-
-	for subIndex := range task.Children {
-		subTask := task.Children[subIndex]
+	// the child tasks are started in the scheduled order, within the parallel limits and the limit of each resource
+	var children []*Task
+	for _, subTask := range task.scheduledChildren() {
 		if subTask.Config.CmdString != "" && subTask.Command.EstimatedRuntime != -1 {
 			// this is a sub task with an eta
-			if remainingParallelTasks == 0 {
-
-				// we've started all possible tasks, now they should stop...
-				// select the first task to stop
-				remainingParallelTasks++
-				minEndSecond, _, err := MinMax(taskEndSecond)
-				checkError(err, "No min eta for empty array!")
-				taskEndSecond = removeOneValue(taskEndSecond, minEndSecond)
-				currentSecond = minEndSecond
-			}
-
-			// we are still starting tasks
-			taskEndSecond = append(taskEndSecond, currentSecond+subTask.Command.EstimatedRuntime.Seconds())
-			remainingParallelTasks--
-
-			_, maxEndSecond, err := MinMax(taskEndSecond)
-			checkError(err, "No max eta for empty array!")
-			maxParallelEstimatedRuntime = math.Max(maxParallelEstimatedRuntime, maxEndSecond)
+			children = append(children, subTask)
 		}
-
 	}
-	etaSeconds += maxParallelEstimatedRuntime
+	limit := config.Options.MaxParallelCmds
+	if task.Config.MaxParallel > 0 && task.Config.MaxParallel < limit {
+		limit = task.Config.MaxParallel
+	}
+	etaSeconds += simulateRuntime(children, runtime, limit)
 	return etaSeconds
 }
 
@@ -464,7 +665,7 @@ func (task *Task) CurrentEta() string {
 		running := time.Since(task.Command.StartTime)
 		etaValue = "Unknown!"
 		if task.Command.EstimatedRuntime > 0 {
-			etaValue = showDuration(time.Duration(task.Command.EstimatedRuntime.Seconds()-running.Seconds()) * time.Second)
+			etaValue = showEta(task.Command.EstimatedRuntime-running, task.Command.EstimatedRuntimeLow-running, task.Command.EstimatedRuntimeHigh-running)
 		}
 		eta = fmt.Sprintf(bold("[%s]"), etaValue)
 	}
@@ -489,10 +690,14 @@ func variableSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err
 		return i + 1, data[0:i], nil
 	}
 
-	// Case: it's just too long
-	terminalWidth, _ := terminal.Width()
-	if len(data) > int(terminalWidth*2) {
-		return int(terminalWidth * 2), data[0:int(terminalWidth*2)], nil
+	// Case: it's just too long (split on a character boundary to keep valid utf-8)
+	terminalWidth := newScreen().renderer.Width()
+	if len(data) > terminalWidth*2 {
+		split := terminalWidth * 2
+		for split > 1 && !utf8.RuneStart(data[split]) {
+			split--
+		}
+		return split, data[0:split], nil
 	}
 
 	// TODO: by some ansi escape sequences
@@ -505,20 +710,45 @@ func variableSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err
 	return
 }
 
+// currentEnvironment returns the env vars of the bashful process
+func currentEnvironment() map[string]string {
+	environment := map[string]string{}
+	for _, line := range os.Environ() {
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) == 2 {
+			environment[fields[0]] = fields[1]
+		}
+	}
+	return environment
+}
+
 // runSingleCmd executes a tasks primary command (not child task commands) and monitors command events
 func (task *Task) runSingleCmd(resultChan chan CmdEvent, waiter *sync.WaitGroup, environment map[string]string) {
+	defer waiter.Done()
+
+	// a fatal error fails the command (see recoverFailure)
+	defer func() {
+		if message := recoverFailure(recover()); message != "" {
+			task.Command.StopTime = time.Now()
+			task.ErrorBuffer.WriteString(message + "\n")
+			task.captureOutput(colorOutput(message, red))
+			resultChan <- CmdEvent{Task: task, Status: statusError, Stderr: message, Complete: true, ReturnCode: -1}
+		}
+	}()
+
 	logToMain("Started Task: "+task.Config.Name, infoFormat)
 
-	task.Command.StartTime = time.Now()
+	// the log is opened before the task is reported as running (so the log file is known to every running task)
+	task.LogChan = make(chan LogItem)
+	task.LogFile = newTaskLogFile(task)
+	task.logWritten = make(chan bool)
+	go singleLogger(task.LogChan, task.Config.Name, task.LogFile.Name(), task.logWritten)
+
+	publishEvent(taskEvent("task-started", task))
 
 	resultChan <- CmdEvent{Task: task, Status: statusRunning, ReturnCode: -1}
-	waiter.Add(1)
-	defer waiter.Done()
 
-	tempFile, _ := ioutil.TempFile(config.logCachePath, "")
-	task.LogFile = tempFile
-	task.LogChan = make(chan LogItem)
-	go singleLogger(task.LogChan, task.Config.Name, tempFile.Name())
+	task.LogChan <- LogItem{Name: task.Config.Name, Message: taskLogHeader(task)}
 
 	stdoutPipe, _ := task.Command.Cmd.StdoutPipe()
 	stderrPipe, _ := task.Command.Cmd.StderrPipe()
@@ -528,7 +758,16 @@ func (task *Task) runSingleCmd(resultChan chan CmdEvent, waiter *sync.WaitGroup,
 		task.Command.Cmd.Env = append(task.Command.Cmd.Env, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	task.Command.Cmd.Start()
+	// a command that cannot be started is reported as a failure (see below)
+	startErr := task.Command.Cmd.Start()
+	if startErr == nil {
+		task.processLock.Lock()
+		task.process = task.Command.Cmd.Process
+		if task.pendingSignal != 0 {
+			syscall.Kill(-task.process.Pid, task.pendingSignal)
+		}
+		task.processLock.Unlock()
+	}
 
 	readPipe := func(resultChan chan string, pipe io.ReadCloser) {
 		defer close(resultChan)
@@ -537,7 +776,7 @@ func (task *Task) runSingleCmd(resultChan chan CmdEvent, waiter *sync.WaitGroup,
 		scanner.Split(variableSplitFunc)
 		for scanner.Scan() {
 			message := scanner.Text()
-			resultChan <- vtclean.Clean(message, false)
+			resultChan <- task.cleanOutput(message)
 		}
 	}
 
@@ -550,34 +789,41 @@ func (task *Task) runSingleCmd(resultChan chan CmdEvent, waiter *sync.WaitGroup,
 		select {
 		case stdoutMsg, ok := <-stdoutChan:
 			if ok {
+				task.captureOutput(colorOutput(stdoutMsg, blue))
+				publishTaskOutput(task, "stdout", stdoutMsg)
+				task.LogChan <- LogItem{Name: task.Config.Name, Message: stdoutMsg + "\n"}
+
 				// it seems that we are getting a bit behind... burn off elements without showing them on the screen
-				if len(stdoutChan) > 100 {
+				if len(stdoutChan) > 100 && !config.Cli.PlainUI {
 					continue
 				}
 
 				if task.Config.EventDriven {
 					// this is event driven... (signal this event)
-					resultChan <- CmdEvent{Task: task, Status: statusRunning, Stdout: blue(stdoutMsg), ReturnCode: -1}
+					resultChan <- CmdEvent{Task: task, Status: statusRunning, Stdout: colorOutput(stdoutMsg, blue), ReturnCode: -1}
 				} else {
 					// on a polling interval... (do not create an event)
-					task.Display.Values.Msg = blue(stdoutMsg)
+					task.Display.Values.Msg = colorOutput(stdoutMsg, blue)
+					task.appendTail(colorOutput(stdoutMsg, blue))
 				}
-				task.LogChan <- LogItem{Name: task.Config.Name, Message: stdoutMsg + "\n"}
 
 			} else {
 				stdoutChan = nil
 			}
 		case stderrMsg, ok := <-stderrChan:
 			if ok {
+				task.captureOutput(colorOutput(stderrMsg, red))
+				publishTaskOutput(task, "stderr", stderrMsg)
 
 				if task.Config.EventDriven {
 					// either this is event driven... (signal this event)
-					resultChan <- CmdEvent{Task: task, Status: statusRunning, Stderr: red(stderrMsg), ReturnCode: -1}
+					resultChan <- CmdEvent{Task: task, Status: statusRunning, Stderr: colorOutput(stderrMsg, red), ReturnCode: -1}
 				} else {
 					// or on a polling interval... (do not create an event)
-					task.Display.Values.Msg = red(stderrMsg)
+					task.Display.Values.Msg = colorOutput(stderrMsg, red)
+					task.appendTail(colorOutput(stderrMsg, red))
 				}
-				task.LogChan <- LogItem{Name: task.Config.Name, Message: red(stderrMsg) + "\n"}
+				task.LogChan <- LogItem{Name: task.Config.Name, Message: colorOutput(stderrMsg, red) + "\n"}
 				task.ErrorBuffer.WriteString(stderrMsg + "\n")
 			} else {
 				stderrChan = nil
@@ -590,7 +836,11 @@ func (task *Task) runSingleCmd(resultChan chan CmdEvent, waiter *sync.WaitGroup,
 
 	returnCode := 0
 	returnCodeMsg := "unknown"
-	if err := task.Command.Cmd.Wait(); err != nil {
+	err := startErr
+	if err == nil {
+		err = task.Command.Cmd.Wait()
+	}
+	if err != nil {
 		if exiterr, ok := err.(*exec.ExitError); ok {
 			// The program has exited with an exit code != 0
 			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
@@ -602,6 +852,7 @@ func (task *Task) runSingleCmd(resultChan chan CmdEvent, waiter *sync.WaitGroup,
 			resultChan <- CmdEvent{Task: task, Status: statusError, Stderr: returnCodeMsg, ReturnCode: returnCode}
 			task.LogChan <- LogItem{Name: task.Config.Name, Message: red(returnCodeMsg) + "\n"}
 			task.ErrorBuffer.WriteString(returnCodeMsg + "\n")
+			task.captureOutput(colorOutput(returnCodeMsg, red))
 		}
 	}
 	task.Command.StopTime = time.Now()
@@ -613,70 +864,273 @@ func (task *Task) runSingleCmd(resultChan chan CmdEvent, waiter *sync.WaitGroup,
 	data, err := ioutil.ReadAll(task.Command.EnvReadFile)
 	checkError(err, "Could not read env vars from child shell")
 
+	lines := strings.Split(string(data[:]), "\n")
+	for _, line := range lines {
+		fields := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(fields) == 2 {
+			task.Command.Environment[fields[0]] = fields[1]
+		} else if len(fields) == 1 && fields[0] != "" {
+			task.Command.Environment[fields[0]] = ""
+		}
+	}
+
+	// the child shell inherits the bashful process env vars unless an environment was explicitly provided
+	parentEnvironment := environment
+	if len(parentEnvironment) == 0 {
+		parentEnvironment = currentEnvironment()
+	}
+	// the env vars are not reported when the command exits the child shell itself (there is no delta to show)
+	if len(task.Command.Environment) > 0 {
+		task.Command.EnvironmentDelta = environmentDelta(parentEnvironment, task.Command.Environment)
+	}
+	task.LogChan <- LogItem{Name: task.Config.Name, Message: taskLogFooter(task, returnCode, task.Command.EnvironmentDelta)}
+
 	if environment != nil {
-		lines := strings.Split(string(data[:]), "\n")
-		for _, line := range lines {
-			fields := strings.SplitN(strings.TrimSpace(line), "=", 2)
-			if len(fields) == 2 {
-				environment[fields[0]] = fields[1]
-			} else if len(fields) == 1 {
-				environment[fields[0]] = ""
-			}
+		for key, value := range task.Command.Environment {
+			environment[key] = value
 		}
 	}
 
+	publishTaskCompleted(task, returnCode)
+
 	if returnCode == 0 || task.Config.IgnoreFailure {
 		resultChan <- CmdEvent{Task: task, Status: statusSuccess, Complete: true, ReturnCode: returnCode}
 	} else {
 		resultChan <- CmdEvent{Task: task, Status: statusError, Complete: true, ReturnCode: returnCode}
-		if task.Config.StopOnFailure {
-			exitSignaled = true
-		}
 	}
 }
 
 // Pave prints the initial task (and child task) formatted status to the screen using newline characters to advance rows (not ansi control codes)
 func (task *Task) Pave() {
+	task.layoutFrame()
+
+	if task.Config.CmdString != "" {
+		task.Display.Values = LineInfo{Status: statusPending.Color("i"), Symbol: statusPending.Symbol(), Title: task.Config.Name}
+	}
+
+	for line := 0; line < len(task.Children); line++ {
+		task.Children[line].Display.Values = LineInfo{Status: statusPending.Color("i"), Symbol: statusPending.Symbol(), Title: task.Children[line].Config.Name}
+	}
+
+	task.displayFrame()
+}
+
+// layoutFrame reserves screen rows for the task (and child tasks) and prints the group header. When there are more tasks than terminal rows, only a viewport of rows is reserved.
+func (task *Task) layoutFrame() {
 	var message bytes.Buffer
-	hasParentCmd := task.Config.CmdString != ""
 	hasHeader := len(task.Children) > 0
 	numTasks := len(task.Children)
-	if hasParentCmd {
+	if task.Config.CmdString != "" {
 		numTasks++
 	}
 	scr := newScreen()
-	scr.ResetFrame(numTasks, hasHeader, config.Options.ShowSummaryFooter)
+
+	task.Display.ViewportRows = 0
+	numRows, _ := task.layoutRows()
+	maxRows := maxFrameRows(hasHeader)
+	if maxRows > 0 && numTasks > maxRows {
+		task.Display.ViewportRows = maxRows
+		numRows = maxRows
+	}
+
+	scr.ResetFrame(numRows, hasHeader, config.Options.ShowSummaryFooter)
 
 	// make room for the title of a parallel proc group
 	if hasHeader {
 		message.Reset()
-		lineObj := LineInfo{Status: statusRunning.Color("i"), Title: task.Config.Name, Msg: "", Prefix: config.Options.BulletChar}
+		lineObj := LineInfo{Status: statusRunning.Color("i"), Symbol: statusRunning.Symbol(), Title: task.Config.Name, Msg: "", Prefix: config.Options.BulletChar}
 		task.Display.Template.Execute(&message, lineObj)
 		scr.DisplayHeader(message.String())
 	}
+}
+
+// maxFrameRows returns the number of task rows that fit on the terminal alongside the header, footer, and the line the cursor rests on (0 indicates no limit)
+func maxFrameRows(hasHeader bool) int {
+	height := newScreen().renderer.Height()
+	if height <= 0 {
+		return 0
+	}
+
+	maxRows := height - 1
+	if hasHeader {
+		maxRows--
+	}
+	if config.Options.ShowSummaryFooter {
+		maxRows--
+	}
+	if maxRows < 2 {
+		maxRows = 2
+	}
+	return maxRows
+}
+
+// layoutRows assigns a screen row to the task and all child tasks (running tasks may use several rows to show an output tail). Returns the total number of rows used and whether the row of any task has changed.
+func (task *Task) layoutRows() (int, bool) {
+	var tasks []*Task
+	if task.Config.CmdString != "" {
+		tasks = append(tasks, task)
+	}
+	tasks = append(tasks, task.Children...)
+
+	// output tails are only shown if there is enough room on the terminal for them
+	withTail := true
+	totalRows := 0
+	for _, t := range tasks {
+		totalRows += t.rowHeight(true)
+	}
+	if maxRows := maxFrameRows(len(task.Children) > 0); maxRows > 0 && totalRows > maxRows {
+		withTail = false
+	}
+
+	row := 0
+	changed := false
+	for _, t := range tasks {
+		if t.Display.Index != row {
+			changed = true
+			t.Display.Index = row
+		}
+		t.Display.TailRows = t.rowHeight(withTail) - 1
+		row += t.rowHeight(withTail)
+	}
+	return row, changed
+}
+
+// relayout adjusts the screen frame when the rows used by the tasks have changed (e.g. an output tail has appeared or collapsed). Returns true if the entire frame must be redrawn.
+func (task *Task) relayout() bool {
+	if task.Display.ViewportRows > 0 || config.Options.SingleLineDisplay {
+		return false
+	}
+
+	scr := newScreen()
+	numRows, changed := task.layoutRows()
+	if numRows != scr.numLines {
+		scr.ResizeFrame(numRows)
+		changed = true
+	}
+	return changed
+}
+
+// displayFrame prints the current status of the task and all child tasks to the screen
+func (task *Task) displayFrame() {
+	if task.Display.ViewportRows > 0 {
+		task.displayViewport()
+		return
+	}
 
-	if hasParentCmd {
-		task.Display.Values = LineInfo{Status: statusPending.Color("i"), Title: task.Config.Name}
+	if task.Config.CmdString != "" {
 		task.display()
 	}
+	for _, subTask := range task.Children {
+		subTask.display()
+	}
+}
 
-	for line := 0; line < len(task.Children); line++ {
-		task.Children[line].Display.Values = LineInfo{Status: statusPending.Color("i"), Title: task.Children[line].Config.Name}
-		task.Children[line].display()
+// displayViewport prints the subset of child tasks that fit within the reserved screen rows. Running and failed tasks are
+// always preferred, followed by pending and then successful tasks; all remaining tasks are summarized on the last row.
+func (task *Task) displayViewport() {
+	scr := newScreen()
+	width := scr.renderer.Width()
+	rows := task.Display.ViewportRows
+	row := 0
+
+	if task.Config.CmdString != "" {
+		scr.Display(task.markFocus(task.String(width)), row)
+		row++
+	}
+
+	visible := make([]bool, len(task.Children))
+	available := rows - row
+	if len(task.Children) > available {
+		// reserve the last row for the summary of hidden tasks
+		available--
+	}
+
+	isRunning := func(t *Task) bool { return t.Command.Started && !t.Command.Complete }
+	isFailed := func(t *Task) bool {
+		return t.Command.Complete && t.Command.ReturnCode != 0 && !t.Config.IgnoreFailure
+	}
+	isPending := func(t *Task) bool { return !t.Command.Started }
+	isFocused := func(t *Task) bool { return interactive.focus == t }
+	index := map[*Task]int{}
+	for idx, subTask := range task.Children {
+		index[subTask] = idx
+	}
+	for _, selector := range []func(*Task) bool{isFocused, isRunning, isFailed, isPending, func(*Task) bool { return true }} {
+		// the pending tasks shown are the next to be started
+		for _, subTask := range task.scheduledChildren() {
+			idx := index[subTask]
+			if available > 0 && !visible[idx] && selector(subTask) {
+				visible[idx] = true
+				available--
+			}
+		}
+	}
+
+	var hiddenPending, hiddenFinished, lastVisible int
+	for idx, subTask := range task.Children {
+		if visible[idx] {
+			lastVisible = idx
+		} else if isPending(subTask) {
+			hiddenPending++
+		} else {
+			hiddenFinished++
+		}
+	}
+	hidden := hiddenPending + hiddenFinished
+
+	for idx, subTask := range task.Children {
+		if !visible[idx] {
+			continue
+		}
+		// the last row should close the tree (this may be the summary of hidden tasks instead)
+		template := subTask.Display.Template
+		if hidden == 0 && idx == lastVisible {
+			subTask.Display.Template = lineLastParallelTemplate
+		} else {
+			subTask.Display.Template = lineParallelTemplate
+		}
+		scr.Display(subTask.markFocus(subTask.String(width)), row)
+		subTask.Display.Template = template
+		row++
+	}
+
+	if hidden > 0 {
+		var message bytes.Buffer
+		lineObj := LineInfo{Status: statusPending.Color("i"), Symbol: statusPending.Symbol(), Title: "+" + strconv.Itoa(hidden) + " more", Msg: purple(fmt.Sprintf("(%d pending, %d finished)", hiddenPending, hiddenFinished))}
+		lineLastParallelTemplate.Execute(&message, lineObj)
+		scr.Display(message.String(), row)
+		row++
+	}
+
+	for ; row < rows; row++ {
+		scr.Display("", row)
+	}
+}
+
+// repaint discards the current screen frame and draws it again (e.g. after the terminal has been resized)
+func (task *Task) repaint() {
+	scr := newScreen()
+	scr.ClearFrame()
+	task.layoutFrame()
+	task.displayFrame()
+	if config.Options.ShowSummaryFooter {
+		scr.DisplayFooter(footer(statusPending, ""))
 	}
 }
 
 // StartAvailableTasks will kick start the maximum allowed number of commands (both primary and child task commands). Repeated invocation will iterate to new commands (and not repeat already completed commands)
 func (task *Task) StartAvailableTasks(environment map[string]string) {
-	if task.Config.CmdString != "" && !task.Command.Started && TaskStats.runningCmds < config.Options.MaxParallelCmds {
-		go task.runSingleCmd(task.resultChan, &task.waiter, environment)
-		task.Command.Started = true
-		TaskStats.runningCmds++
+	if interactive.paused || runCancelled {
+		return
 	}
-	for ; TaskStats.runningCmds < config.Options.MaxParallelCmds && task.lastStartedTask < len(task.Children); task.lastStartedTask++ {
-		go task.Children[task.lastStartedTask].runSingleCmd(task.resultChan, &task.waiter, nil)
-		task.Children[task.lastStartedTask].Command.Started = true
-		TaskStats.runningCmds++
+	if task.Config.CmdString != "" && !task.Command.Started && task.canStart(task) {
+		task.startCommand(task, environment)
+	}
+	// a child task waiting on a resource does not hold back the child tasks after it
+	for _, subTask := range task.scheduledChildren() {
+		if !subTask.Command.Started && task.canStart(subTask) {
+			task.startCommand(subTask, nil)
+		}
 	}
 }
 
@@ -687,26 +1141,64 @@ func (task *Task) Completed(rc int) {
 	close(task.LogChan)
 
 	TaskStats.completedTasks++
-	config.commandTimeCache[task.Config.CmdString] = task.Command.StopTime.Sub(task.Command.StartTime)
+	releaseResources(task)
+	// a cancelled command says little about how long the command takes
+	if !task.Command.Cancelled {
+		recordRuntime(task.Config.etaKey(), task.Config.CmdString, task.Command.StopTime.Sub(task.Command.StartTime), rc == 0)
+	}
 	TaskStats.runningCmds--
 }
 
 // listenAndDisplay updates the screen frame with the latest task and child task updates as they occur (either in realtime or in a polling loop). Returns when all child processes have been completed.
 func (task *Task) listenAndDisplay(environment map[string]string) {
 	scr := newScreen()
+	limiter := frameLimiter{interval: config.Options.frameInterval()}
+
+	// drawFrame brings the screen frame up to date with the latest task state (only rows that have changed are rewritten)
+	drawFrame := func() {
+		task.relayout()
+		task.displayFrame()
+
+		// update the summary line
+		if config.Options.ShowSummaryFooter {
+			scr.DisplayFooter(footer(statusPending, interactive.footerMessage()))
+		} else {
+			scr.MovePastFrame(false)
+		}
+		limiter.drawn()
+	}
+
 	// just wait for stuff to come back
 
-	for TaskStats.runningCmds > 0 {
+	// note: while paused (from the interactive mode or the control socket) there may be no running commands, but there is still work to do
+	for TaskStats.runningCmds > 0 || (interactive.paused && task.hasUnstartedTasks()) {
 		select {
 		case <-ticker.C:
+			publishSnapshot()
+
+			// commands held back while the system was busy are started once the load drops
+			if (config.Options.ThrottleLoad > 0 || config.Options.ThrottleMemory > 0) && throttleReason() == "" {
+				task.StartAvailableTasks(environment)
+			}
+
+			// plain output is only written on events (there is no screen frame to refresh)
+			if config.Cli.PlainUI {
+				continue
+			}
+
 			spinner.Next()
 
+			// the screen frame is hidden while an overlay is shown
+			if interactive.overlayShown() {
+				interactive.refreshOverlay()
+				continue
+			}
+
 			if task.Config.CmdString != "" {
 				if !task.Command.Complete && task.Command.Started {
 					task.Display.Values.Prefix = spinner.Current()
 					task.Display.Values.Eta = task.CurrentEta()
 				}
-				task.display()
 			}
 
 			for _, taskObj := range task.Children {
@@ -714,14 +1206,33 @@ func (task *Task) listenAndDisplay(environment map[string]string) {
 					taskObj.Display.Values.Prefix = spinner.Current()
 					taskObj.Display.Values.Eta = taskObj.CurrentEta()
 				}
-				taskObj.display()
 			}
 
-			// update the summary line
-			if config.Options.ShowSummaryFooter {
-				scr.DisplayFooter(footer(statusPending, ""))
+			drawFrame()
+
+		case <-limiter.pending:
+			// a frame was skipped to stay within the frame rate, draw the latest state now
+			limiter.pending = nil
+			if !interactive.overlayShown() {
+				drawFrame()
+			}
+
+		case <-terminalResized:
+			if interactive.overlayShown() {
+				interactive.refreshOverlay()
+			} else if !config.Options.SingleLineDisplay && !config.Cli.PlainUI {
+				task.repaint()
 			}
 
+		case key := <-keyPresses:
+			task.handleKey(key, environment)
+
+		case request := <-controlRequests:
+			task.handleControl(request, environment)
+
+		case <-cancelRequests:
+			cancelRun()
+
 		case msgObj := <-task.resultChan:
 			eventTask := msgObj.Task
 
@@ -729,34 +1240,56 @@ func (task *Task) listenAndDisplay(environment map[string]string) {
 			if msgObj.Complete {
 				eventTask.Completed(msgObj.ReturnCode)
 				task.StartAvailableTasks(environment)
-				task.status = msgObj.Status
+				// once any command has failed the group is shown as failed (whichever command completes last)
+				if len(task.failedTasks) == 0 {
+					task.status = msgObj.Status
+				}
 				if msgObj.Status == statusError {
 					// update the group status to indicate a failed subtask
 					TaskStats.totalFailedTasks++
 
 					// keep note of the failed task for an after task report
 					task.failedTasks = append(task.failedTasks, eventTask)
+
+					// a task cancelled by the user should not stop any other tasks
+					if eventTask.Config.StopOnFailure && !eventTask.Command.Cancelled {
+						exitSignaled = true
+					}
 				}
 			}
 
+			if config.Cli.PlainUI {
+				plainDisplayEvent(msgObj)
+				continue
+			}
+
 			if !eventTask.Config.ShowTaskOutput {
 				msgObj.Stderr = ""
 				msgObj.Stdout = ""
 			}
 
 			if msgObj.Stderr != "" {
-				eventTask.Display.Values = LineInfo{Status: msgObj.Status.Color("i"), Title: eventTask.Config.Name, Msg: msgObj.Stderr, Prefix: spinner.Current(), Eta: eventTask.CurrentEta()}
+				eventTask.appendTail(msgObj.Stderr)
+			} else if msgObj.Stdout != "" {
+				eventTask.appendTail(msgObj.Stdout)
+			}
+
+			if msgObj.Stderr != "" {
+				eventTask.Display.Values = LineInfo{Status: msgObj.Status.Color("i"), Symbol: msgObj.Status.Symbol(), Title: eventTask.Config.Name, Msg: msgObj.Stderr, Prefix: spinner.Current(), Eta: eventTask.CurrentEta()}
 			} else {
-				eventTask.Display.Values = LineInfo{Status: msgObj.Status.Color("i"), Title: eventTask.Config.Name, Msg: msgObj.Stdout, Prefix: spinner.Current(), Eta: eventTask.CurrentEta()}
+				eventTask.Display.Values = LineInfo{Status: msgObj.Status.Color("i"), Symbol: msgObj.Status.Symbol(), Title: eventTask.Config.Name, Msg: msgObj.Stdout, Prefix: spinner.Current(), Eta: eventTask.CurrentEta()}
 			}
 
-			eventTask.display()
+			if interactive.overlayShown() {
+				if interactive.inspecting == eventTask {
+					interactive.refreshOverlay()
+				}
+				continue
+			}
 
-			// update the summary line
-			if config.Options.ShowSummaryFooter {
-				scr.DisplayFooter(footer(statusPending, ""))
-			} else {
-				scr.MovePastFrame(false)
+			// output events are coalesced to stay within the frame rate, but a completed task is always shown right away
+			if msgObj.Complete || limiter.ready() {
+				drawFrame()
 			}
 
 		}
@@ -769,16 +1302,65 @@ func (task *Task) listenAndDisplay(environment map[string]string) {
 
 }
 
+// execute starts the task command and all child task commands, returning after all of them have completed
+func (task *Task) execute(environment map[string]string) {
+	task.groupStartTime = time.Now()
+	// the run may have been cancelled (from the api server) before this task started
+	acceptCancelRequest()
+	task.StartAvailableTasks(environment)
+	task.listenAndDisplay(environment)
+	task.groupStopTime = time.Now()
+	publishSnapshot()
+}
+
+// discardEvents consumes the events of every running command of the task until all of them have completed (once the
+// run has failed there is no main loop left to handle them)
+func (task *Task) discardEvents() {
+	done := make(chan bool)
+	go func() {
+		task.waiter.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case event := <-task.resultChan:
+			if event.Complete {
+				close(event.Task.LogChan)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
 // Run will run the current tasks primary command and/or all child commands. When execution has completed, the screen frame will advance.
 func (task *Task) Run(environment map[string]string) {
 
 	var message bytes.Buffer
 
+	if config.Cli.PlainUI {
+		if len(task.Children) > 0 {
+			plainDisplayStart(task)
+		}
+		task.execute(environment)
+		if len(task.Children) > 0 {
+			plainDisplayGroupComplete(task)
+		}
+		return
+	}
+
+	interactive.focus = nil
 	if !config.Options.SingleLineDisplay {
 		task.Pave()
 	}
-	task.StartAvailableTasks(environment)
-	task.listenAndDisplay(environment)
+	task.execute(environment)
+
+	// the completed frame must be visible (not hidden by an overlay) before moving past it
+	if interactive.overlayShown() {
+		interactive.closeOverlay()
+		task.displayFrame()
+	}
 
 	scr := newScreen()
 	hasHeader := len(task.Children) > 0 && !config.Options.SingleLineDisplay
@@ -791,7 +1373,7 @@ func (task *Task) Run(environment map[string]string) {
 		if collapseSection {
 			collapseSummary = purple(" (" + strconv.Itoa(len(task.Children)) + " tasks hidden)")
 		}
-		task.Display.Template.Execute(&message, LineInfo{Status: task.status.Color("i"), Title: task.Config.Name + collapseSummary, Prefix: config.Options.BulletChar})
+		task.Display.Template.Execute(&message, LineInfo{Status: task.status.Color("i"), Symbol: task.status.Symbol(), Title: task.Config.Name + collapseSummary, Prefix: config.Options.BulletChar})
 		scr.DisplayHeader(message.String())
 	}
 
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/alecthomas/repr"
 )
@@ -32,6 +36,29 @@ func TestTaskString(t *testing.T) {
 
 }
 
+func TestTaskStringUnicode(t *testing.T) {
+	config.Options.ShowTaskEta = true
+	defer func() { config.Options.ShowTaskEta = false }()
+
+	// the eta column should line up regardless of wide or combining characters in the title or message
+	for _, title := range []string{"ascii title", "日本語のタスク", "🚀 launch", "cafe\u0301"} {
+		task := NewTask(TaskConfig{Name: title, CmdString: "/bin/true"}, 1, "2")
+		task.Display.Values = LineInfo{Status: statusSuccess.Color(""), Title: title, Msg: "出力 ✨ output", Prefix: "$", Eta: "ETA"}
+
+		line := task.String(50)
+		if visualLength(line) != 50 {
+			t.Error("TestTaskStringUnicode: Expected a width of 50 for", repr.String(title), "got", visualLength(line), repr.String(line))
+		}
+	}
+
+	// a long command (used as the task name) is shortened without splitting a character
+	task := NewTask(TaskConfig{CmdString: "echo 日本語日本語日本語日本語日本語"}, 1, "2")
+	task.String(50)
+	if !utf8.ValidString(task.Config.Name) || visualLength(task.Config.Name) > theme.TitleWidth {
+		t.Error("TestTaskStringUnicode: Expected a valid name within the title width, got", repr.String(task.Config.Name))
+	}
+}
+
 func TestSerialTaskEnvPersistence(t *testing.T) {
 	var expStr, actStr string
 	var failedTasks []*Task
@@ -70,3 +97,78 @@ tasks:
 	}
 
 }
+
+func TestOutputTail(t *testing.T) {
+	yamlStr := `
+config:
+  show-summary-footer: false
+  output-lines: 2
+tasks:
+  - name: Building
+    parallel-tasks:
+      - name: compile
+        cmd: make
+      - name: lint
+        cmd: make lint
+        output-lines: 0
+      - name: test
+        cmd: make test
+`
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	term := newVirtualTerminal(60, 24)
+	scr.SetRenderer(term)
+
+	parseRunYaml([]byte(yamlStr))
+	tasks := CreateTasks()
+	group := tasks[0]
+	group.Pave()
+
+	// running tasks reserve rows below the status line for the most recent output
+	for _, subTask := range group.Children {
+		subTask.Command.Started = true
+		subTask.Display.Values.Status = statusRunning.Color("i")
+		for _, line := range []string{"first line", "second line", "third line"} {
+			subTask.appendTail(subTask.Config.Name + ": " + line)
+		}
+	}
+	if !group.relayout() {
+		t.Error("TestOutputTail: Expected the frame to change once tasks are running")
+	}
+	group.displayFrame()
+	scr.MovePastFrame(false)
+	assertGoldenScreen(t, "output-tail-running", term)
+
+	// completed tasks collapse back to a single line
+	term = newVirtualTerminal(60, 24)
+	scr.SetRenderer(term)
+	group.Pave()
+	group.relayout()
+	group.Children[2].Command.Complete = true
+	group.Children[2].Command.ReturnCode = 0
+	group.relayout()
+	group.displayFrame()
+	scr.MovePastFrame(false)
+	assertGoldenScreen(t, "output-tail-collapsed", term)
+}
+
+func TestTaskStartFailure(t *testing.T) {
+	// a command cancelled before its process has started is stopped once it starts
+	task := NewTask(TaskConfig{Name: "pending", CmdString: "sleep 10"}, 1, "")
+	task.Command.Started = true
+	task.Cancel()
+	if !task.Command.Cancelled || task.pendingSignal != syscall.SIGTERM {
+		t.Error("TestTaskStartFailure: Expected the cancel to wait for the process to start, got", task.pendingSignal)
+	}
+
+	// a command that cannot be started is a failed task
+	shell := os.Getenv("SHELL")
+	defer os.Setenv("SHELL", shell)
+	os.Setenv("SHELL", "/does/not/exist/sh")
+
+	config.Options.StopOnFailure = false
+	failedTasks := run([]byte("tasks:\n  - name: broken\n    cmd: echo never\n"), map[string]string{})
+	if len(failedTasks) != 1 || !strings.Contains(failedTasks[0].ErrorBuffer.String(), "Failed to run") {
+		t.Error("TestTaskStartFailure: Expected the task to fail to start, got", len(failedTasks), "failed tasks")
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lunixbochs/vtclean"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultSuiteName is the test suite name given to all top-level (non-parallel) tasks
+const defaultSuiteName = "bashful"
+
+// testCase is the outcome of a single task as shown on a test report
+type testCase struct {
+	name     string
+	duration time.Duration
+	failed   bool
+	skipped  bool
+	message  string
+	stderr   string
+}
+
+// testSuite is a group of test cases (a parallel task, or all top-level tasks) as shown on a test report
+type testSuite struct {
+	name     string
+	duration time.Duration
+	cases    []testCase
+}
+
+// count returns the number of test cases that failed and that were skipped within the suite
+func (suite *testSuite) count() (failures, skipped int) {
+	for _, test := range suite.cases {
+		if test.failed {
+			failures++
+		} else if test.skipped {
+			skipped++
+		}
+	}
+	return failures, skipped
+}
+
+// newTestCase describes the outcome of the given task (any task in failedTasks is a failure, a task that never completed is skipped)
+func newTestCase(task *Task, failed map[*Task]bool) testCase {
+	test := testCase{name: task.Config.Name}
+	switch {
+	case failed[task]:
+		test.failed = true
+		test.message = "return code " + strconv.Itoa(task.Command.ReturnCode)
+		if task.Command.Cancelled {
+			test.message = "cancelled (" + test.message + ")"
+		}
+		test.stderr = plainText(strings.TrimRight(task.ErrorBuffer.String(), "\n"))
+	case !task.Command.Complete:
+		test.skipped = true
+		test.message = "not run"
+	}
+	if task.Command.Complete {
+		test.duration = task.Command.StopTime.Sub(task.Command.StartTime)
+	}
+	return test
+}
+
+// testSuites maps every parallel task to a test suite of its children (all top-level tasks share the default suite), along
+// with all tasks pruned by the cli options as skipped test cases
+func testSuites(tasks []*Task, failedTasks []*Task) []*testSuite {
+	failed := make(map[*Task]bool)
+	for _, task := range failedTasks {
+		failed[task] = true
+	}
+
+	var suites []*testSuite
+	suiteByName := make(map[string]*testSuite)
+	suite := func(name string) *testSuite {
+		if _, ok := suiteByName[name]; !ok {
+			suiteByName[name] = &testSuite{name: name}
+			suites = append(suites, suiteByName[name])
+		}
+		return suiteByName[name]
+	}
+
+	for _, task := range tasks {
+		if len(task.Children) == 0 {
+			test := newTestCase(task, failed)
+			defaultSuite := suite(defaultSuiteName)
+			defaultSuite.cases = append(defaultSuite.cases, test)
+			defaultSuite.duration += test.duration
+			continue
+		}
+
+		groupSuite := suite(task.Config.Name)
+		for _, subTask := range task.Children {
+			groupSuite.cases = append(groupSuite.cases, newTestCase(subTask, failed))
+		}
+		if !task.groupStopTime.IsZero() {
+			groupSuite.duration = task.groupStopTime.Sub(task.groupStartTime)
+		}
+	}
+
+	for _, pruned := range config.prunedTasks {
+		name := pruned.Group
+		if name == "" {
+			name = defaultSuiteName
+		}
+		prunedSuite := suite(name)
+		prunedSuite.cases = append(prunedSuite.cases, testCase{name: pruned.Config.Name, skipped: true, message: pruned.Reason})
+	}
+
+	return suites
+}
+
+// plainText removes all ansi values from every line of the given command output
+func plainText(output string) string {
+	lines := strings.Split(output, "\n")
+	for idx, line := range lines {
+		lines[idx] = vtclean.Clean(line, false)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// showSeconds formats the given duration as fractional seconds (as used by junit reports)
+func showSeconds(duration time.Duration) string {
+	return strconv.FormatFloat(duration.Seconds(), 'f', 3, 64)
+}
+
+// junitTestSuites is the root element of a junit report (one for the whole run)
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is a single test suite element of a junit report
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a single test case element of a junit report
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+// junitMessage is a failure or skipped element of a junit test case (the contents hold the captured stderr)
+type junitMessage struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+// junitReport returns the given test suites as a junit xml document
+func junitReport(suites []*testSuite) string {
+	var total time.Duration
+	document := junitTestSuites{Name: defaultSuiteName}
+	for _, suite := range suites {
+		failures, skipped := suite.count()
+		junitSuite := junitTestSuite{Name: suite.name, Tests: len(suite.cases), Failures: failures, Skipped: skipped, Time: showSeconds(suite.duration)}
+		for _, test := range suite.cases {
+			junitCase := junitTestCase{Name: test.name, Classname: suite.name, Time: showSeconds(test.duration)}
+			if test.failed {
+				junitCase.Failure = &junitMessage{Message: test.message, Contents: test.stderr}
+			} else if test.skipped {
+				junitCase.Skipped = &junitMessage{Message: test.message}
+			}
+			junitSuite.Cases = append(junitSuite.Cases, junitCase)
+		}
+
+		document.Suites = append(document.Suites, junitSuite)
+		document.Tests += junitSuite.Tests
+		document.Failures += failures
+		document.Skipped += skipped
+		total += suite.duration
+	}
+	document.Time = showSeconds(total)
+
+	data, err := xml.MarshalIndent(document, "", "  ")
+	checkError(err, "Unable to create junit report")
+	return xml.Header + string(data) + "\n"
+}
+
+// tapDiagnostic is the yaml block shown below a single tap test line
+type tapDiagnostic struct {
+	DurationMs int64  `yaml:"duration_ms"`
+	Message    string `yaml:"message,omitempty"`
+	Stderr     string `yaml:"stderr,omitempty"`
+}
+
+// tapReport returns the given test suites as a tap (version 13) document
+func tapReport(suites []*testSuite) string {
+	var buffer bytes.Buffer
+	var lines []string
+	for _, suite := range suites {
+		for _, test := range suite.cases {
+			description := test.name
+			if suite.name != defaultSuiteName {
+				description = suite.name + ": " + test.name
+			}
+			description = strings.Replace(description, "#", "\\#", -1)
+
+			number := strconv.Itoa(len(lines) + 1)
+			if test.skipped {
+				lines = append(lines, "ok "+number+" - "+description+" # SKIP "+test.message+"\n")
+				continue
+			}
+
+			line := "ok " + number + " - " + description + "\n"
+			diagnostic := tapDiagnostic{DurationMs: int64(test.duration / time.Millisecond)}
+			if test.failed {
+				line = "not " + line
+				diagnostic.Message = test.message
+				diagnostic.Stderr = test.stderr
+			}
+
+			data, err := yaml.Marshal(diagnostic)
+			checkError(err, "Unable to create tap report")
+			line += "  ---\n"
+			for _, diagnosticLine := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+				line += "  " + diagnosticLine + "\n"
+			}
+			line += "  ...\n"
+			lines = append(lines, line)
+		}
+	}
+
+	buffer.WriteString("TAP version 13\n")
+	buffer.WriteString("1.." + strconv.Itoa(len(lines)) + "\n")
+	for _, line := range lines {
+		buffer.WriteString(line)
+	}
+	return buffer.String()
+}
+
+// writeReports writes every report requested from the command line (see the 'report' option)
+func writeReports(tasks []*Task, failedTasks []*Task) {
+	if len(config.Cli.Reports) == 0 {
+		return
+	}
+
+	suites := testSuites(tasks, failedTasks)
+	for format, path := range config.Cli.Reports {
+		var contents string
+		switch format {
+		case "junit":
+			contents = junitReport(suites)
+		case "tap":
+			contents = tapReport(suites)
+		}
+		err := ioutil.WriteFile(path, []byte(contents), 0644)
+		checkError(err, "Unable to write "+format+" report")
+	}
+}
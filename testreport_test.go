@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/repr"
+	"github.com/deckarep/golang-set"
+)
+
+// reportTasks returns a completed top-level task, a parallel group with a passing, failing, and unstarted task, and the failed tasks
+func reportTasks() ([]*Task, []*Task) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	completed := func(name string, runtime time.Duration, returnCode int) *Task {
+		task := NewTask(TaskConfig{Name: name, CmdString: "true"}, 1, "")
+		task.Command.Complete = true
+		task.Command.ReturnCode = returnCode
+		task.Command.StartTime = start
+		task.Command.StopTime = start.Add(runtime)
+		return task
+	}
+
+	failed := completed("compile b", 250*time.Millisecond, 3)
+	failed.ErrorBuffer = bytes.NewBufferString("\x1b[31mb is broken\x1b[0m\nexiting\n")
+
+	group := NewTask(TaskConfig{Name: "Compiling"}, 1, "")
+	group.Children = []*Task{completed("compile a", 500*time.Millisecond, 0), failed, NewTask(TaskConfig{Name: "compile c", CmdString: "true"}, 1, "")}
+	group.groupStartTime = start
+	group.groupStopTime = start.Add(time.Second)
+
+	return []*Task{completed("setup", 1500*time.Millisecond, 0), group}, []*Task{failed}
+}
+
+func TestJunitReport(t *testing.T) {
+	defer func() { config.prunedTasks = nil }()
+	config.prunedTasks = []prunedTask{{Group: "Compiling", Config: TaskConfig{Name: "compile d"}, Reason: prunedByTags}}
+
+	tasks, failedTasks := reportTasks()
+	expected := strings.Join([]string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<testsuites name="bashful" tests="5" failures="1" skipped="2" time="2.500">`,
+		`  <testsuite name="bashful" tests="1" failures="0" skipped="0" time="1.500">`,
+		`    <testcase name="setup" classname="bashful" time="1.500"></testcase>`,
+		`  </testsuite>`,
+		`  <testsuite name="Compiling" tests="4" failures="1" skipped="2" time="1.000">`,
+		`    <testcase name="compile a" classname="Compiling" time="0.500"></testcase>`,
+		`    <testcase name="compile b" classname="Compiling" time="0.250">`,
+		`      <failure message="return code 3">b is broken&#xA;exiting</failure>`,
+		`    </testcase>`,
+		`    <testcase name="compile c" classname="Compiling" time="0.000">`,
+		`      <skipped message="not run"></skipped>`,
+		`    </testcase>`,
+		`    <testcase name="compile d" classname="Compiling" time="0.000">`,
+		`      <skipped message="not matching the given tags"></skipped>`,
+		`    </testcase>`,
+		`  </testsuite>`,
+		`</testsuites>`,
+		``,
+	}, "\n")
+
+	actual := junitReport(testSuites(tasks, failedTasks))
+	if expected != actual {
+		t.Error("TestJunitReport: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+}
+
+func TestTapReport(t *testing.T) {
+	tasks, failedTasks := reportTasks()
+	expected := strings.Join([]string{
+		"TAP version 13",
+		"1..4",
+		"ok 1 - setup",
+		"  ---",
+		"  duration_ms: 1500",
+		"  ...",
+		"ok 2 - Compiling: compile a",
+		"  ---",
+		"  duration_ms: 500",
+		"  ...",
+		"not ok 3 - Compiling: compile b",
+		"  ---",
+		"  duration_ms: 250",
+		"  message: return code 3",
+		"  stderr: |-",
+		"    b is broken",
+		"    exiting",
+		"  ...",
+		"ok 4 - Compiling: compile c # SKIP not run",
+		"",
+	}, "\n")
+
+	actual := tapReport(testSuites(tasks, failedTasks))
+	if expected != actual {
+		t.Error("TestTapReport: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+}
+
+func TestPrunedTasks(t *testing.T) {
+	yamlStr := `
+tasks:
+  - name: untagged
+    cmd: "true"
+  - name: tagged
+    cmd: "true"
+    tags: other
+  - name: Group
+    parallel-tasks:
+      - cmd: echo matched
+        tags: app
+      - cmd: echo unmatched
+        tags: other
+`
+	defer func() {
+		config.Cli.RunTags = nil
+		config.Cli.RunTagSet = nil
+		config.prunedTasks = nil
+	}()
+	config.Cli.RunTags = []string{"app"}
+	config.Cli.RunTagSet = mapset.NewSetFromSlice([]interface{}{"app"})
+	parseRunYaml([]byte(yamlStr))
+
+	var actual []string
+	for _, pruned := range config.prunedTasks {
+		actual = append(actual, pruned.Group+"/"+pruned.Config.Name)
+	}
+	expected := []string{"/tagged", "Group/echo unmatched"}
+	if repr.String(expected) != repr.String(actual) {
+		t.Error("TestPrunedTasks: Expected", repr.String(expected), "got", repr.String(actual))
+	}
+}
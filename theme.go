@@ -0,0 +1,218 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"text/template"
+
+	color "github.com/mgutz/ansi"
+	"github.com/tj/go-spin"
+)
+
+var (
+	// theme is the active set of templates, glyphs, and symbols used to draw the screen (see applyTheme)
+	theme = NewThemeConfig()
+
+	// colorsDisabled indicates that no ansi color values should be written (from the 'monochrome' theme option or the NO_COLOR env var)
+	colorsDisabled bool
+
+	// monochromeSymbols are the status symbols used when colors are disabled (and no symbols are configured), otherwise every status would look the same
+	monochromeSymbols = StatusSymbolsConfig{Running: "> ", Pending: "  ", Success: "✓ ", Error: "✗ "}
+)
+
+const (
+	// defaultLineTemplate is the string template used to display the status values of a single task
+	defaultLineTemplate = ` {{.Status}}{{symbol .Symbol}}{{reset}} {{printf "%1s" .Prefix}} {{branch}}{{title .Title}} {{.Msg}}{{.Split}}{{.Eta}}`
+
+	// defaultFooterTemplate is the string template used to display the summary footer
+	defaultFooterTemplate = ` {{.Status}}{{symbol .Symbol}}  {{reset}} {{printf "%-16s" .Percent}}{{reset}} {{.Steps}}{{.Errors}}{{.Msg}}{{.Split}}{{.Runtime}}{{.Eta}}`
+
+	// outputTailTemplate is the string template used to display a single line of output below a running task
+	outputTailTemplate = ` {{.Status}}{{symbol ""}}{{reset}}   {{trunk}}{{.Msg}}`
+)
+
+// ThemeConfig is the set of templates, glyphs, and symbols used to draw task lines and the summary footer
+type ThemeConfig struct {
+	// LineTemplate is the go template used to display a single task line (with LineInfo values)
+	LineTemplate string `yaml:"line-template"`
+
+	// FooterTemplate is the go template used to display the summary footer
+	FooterTemplate string `yaml:"footer-template"`
+
+	// BranchGlyph is drawn in front of every parallel task title (except the last)
+	BranchGlyph string `yaml:"branch-glyph"`
+
+	// LastBranchGlyph is drawn in front of the last parallel task title
+	LastBranchGlyph string `yaml:"last-branch-glyph"`
+
+	// TrunkGlyph is drawn below a BranchGlyph (on any output tail rows) to continue the tree
+	TrunkGlyph string `yaml:"trunk-glyph"`
+
+	// Spinner is the sequence of characters (one per frame) shown in front of running tasks
+	Spinner string `yaml:"spinner"`
+
+	// StatusSymbols are drawn within the status column of each task line (by default the status is indicated only by color)
+	StatusSymbols StatusSymbolsConfig `yaml:"status-symbols"`
+
+	// TitleWidth is the number of columns reserved for each task title
+	TitleWidth int `yaml:"title-width"`
+
+	// Monochrome indicates that no ansi color values should be used (the same as setting the NO_COLOR env var)
+	Monochrome bool `yaml:"monochrome"`
+}
+
+// StatusSymbolsConfig is a symbol for each CommandStatus (an empty value is left blank)
+type StatusSymbolsConfig struct {
+	Running string `yaml:"running"`
+	Pending string `yaml:"pending"`
+	Success string `yaml:"success"`
+	Error   string `yaml:"error"`
+}
+
+// NewThemeConfig creates a new ThemeConfig populated with the default look of bashful
+func NewThemeConfig() (obj ThemeConfig) {
+	obj.LineTemplate = defaultLineTemplate
+	obj.FooterTemplate = defaultFooterTemplate
+	obj.BranchGlyph = "├─"
+	obj.LastBranchGlyph = "└─"
+	obj.TrunkGlyph = "│"
+	obj.Spinner = spin.Default
+	obj.TitleWidth = 25
+	obj.Monochrome = false
+	return obj
+}
+
+// UnmarshalYAML parses and creates a ThemeConfig from a given user yaml string
+func (theme *ThemeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type defaults ThemeConfig
+	defaultValues := defaults(NewThemeConfig())
+
+	if err := unmarshal(&defaultValues); err != nil {
+		return err
+	}
+
+	*theme = ThemeConfig(defaultValues)
+	return nil
+}
+
+// validate ensures that the theme can be used to draw the screen
+func (theme *ThemeConfig) validate() {
+	if theme.TitleWidth < 4 {
+		exitWithErrorMessage("Theme option 'title-width' must be at least 4")
+	}
+	if theme.Spinner == "" || strings.ContainsAny(theme.Spinner, " \t") {
+		exitWithErrorMessage("Theme option 'spinner' must be one or more characters (without spaces)")
+	}
+}
+
+// applyTheme makes the given theme active (recompiling all line templates) and enables or disables ansi color values
+func applyTheme(newTheme ThemeConfig) {
+	newTheme.validate()
+	theme = newTheme
+
+	colorsDisabled = theme.Monochrome || os.Getenv("NO_COLOR") != ""
+	color.DisableColors(colorsDisabled)
+	spinner.Set(theme.Spinner)
+
+	templates := []struct {
+		target **template.Template
+		name   string
+		source string
+		option string
+	}{
+		{&lineDefaultTemplate, "default line", theme.LineTemplate, "line-template"},
+		{&lineParallelTemplate, "parallel line", theme.LineTemplate, "line-template"},
+		{&lineLastParallelTemplate, "last parallel line", theme.LineTemplate, "line-template"},
+		{&lineTailTemplate, "tail line", outputTailTemplate, ""},
+		{&lineParallelTailTemplate, "parallel tail line", outputTailTemplate, ""},
+		{&lineLastParallelTailTemplate, "last parallel tail line", outputTailTemplate, ""},
+		{&summaryTemplate, "summary line", theme.FooterTemplate, "footer-template"},
+	}
+	for _, item := range templates {
+		compiled, err := theme.parseTemplate(item.name, item.source)
+		if err != nil {
+			exitWithErrorMessage("Unable to parse theme option '" + item.option + "': " + err.Error())
+		}
+		*item.target = compiled
+	}
+}
+
+// parseTemplate compiles the given line template along with the functions available to it: 'reset' (ends any color),
+// 'symbol' (pads a status symbol to the status column width), 'title' (pads a title to the title width), and 'branch' /
+// 'trunk' (the tree glyphs that apply to the named template)
+func (theme ThemeConfig) parseTemplate(name, source string) (*template.Template, error) {
+	var branch, trunk string
+	switch name {
+	case "parallel line", "parallel tail line":
+		branch, trunk = theme.BranchGlyph, theme.TrunkGlyph
+	case "last parallel line", "last parallel tail line":
+		branch, trunk = theme.LastBranchGlyph, ""
+	}
+	if branch != "" {
+		branch += " "
+		trunk = padToVisualLength(trunk, visualLength(branch))
+	}
+
+	titleWidth := theme.TitleWidth
+	return template.New(name).Funcs(template.FuncMap{
+		"reset":  resetColor,
+		"symbol": statusSymbolCell,
+		"title": func(title string) string {
+			return padToVisualLength(title, titleWidth)
+		},
+		"branch": func() string { return branch },
+		"trunk":  func() string { return trunk },
+	}).Parse(source)
+}
+
+// resetColor returns the ansi value that ends all color and style attributes (nothing if colors are disabled)
+func resetColor() string {
+	if colorsDisabled {
+		return ""
+	}
+	return color.Reset
+}
+
+// symbols returns the status symbols in effect (falling back to monochromeSymbols when there is no color to show the status)
+func (theme ThemeConfig) symbols() StatusSymbolsConfig {
+	if colorsDisabled && theme.StatusSymbols == (StatusSymbolsConfig{}) {
+		return monochromeSymbols
+	}
+	return theme.StatusSymbols
+}
+
+// Symbol returns the themed symbol represented by the given CommandStatus
+func (status CommandStatus) Symbol() string {
+	symbols := theme.symbols()
+	switch status {
+	case statusRunning:
+		return symbols.Running
+	case statusPending:
+		return symbols.Pending
+	case statusSuccess:
+		return symbols.Success
+	case statusError:
+		return symbols.Error
+	}
+	return ""
+}
+
+// statusSymbolCell pads the given status symbol to the width of the status column (the widest symbol, at least 2 columns)
+func statusSymbolCell(symbol string) string {
+	width := 2
+	symbols := theme.symbols()
+	for _, candidate := range []string{symbols.Running, symbols.Pending, symbols.Success, symbols.Error} {
+		if visualLength(candidate) > width {
+			width = visualLength(candidate)
+		}
+	}
+	return padToVisualLength(symbol, width)
+}
+
+// padToVisualLength appends spaces to the given string until it fills the given number of columns
+func padToVisualLength(str string, length int) string {
+	if visualLength(str) >= length {
+		return str
+	}
+	return str + strings.Repeat(" ", length-visualLength(str))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestNoColor(t *testing.T) {
+	yamlStr := `
+config:
+  show-summary-times: false
+tasks:
+  - name: first
+    cmd: echo one
+`
+	scr := newScreen()
+	defer scr.SetRenderer(ansiTerminal{})
+	defer applyTheme(NewThemeConfig())
+
+	os.Setenv("NO_COLOR", "1")
+	defer os.Unsetenv("NO_COLOR")
+
+	term := &recordingTerminal{virtualTerminal: newVirtualTerminal(80, 24)}
+	scr.SetRenderer(term)
+	run([]byte(yamlStr), map[string]string{})
+
+	// cursor movement is still required, but no color or style values should be written
+	if sgr := regexp.MustCompile("\x1b\\[[0-9;]*m").FindString(term.raw.String()); sgr != "" {
+		t.Error("TestNoColor: Expected no color values, got", repr.String(sgr), "in", repr.String(term.raw.String()))
+	}
+	if !strings.Contains(term.String(), monochromeSymbols.Success+" • first") {
+		t.Error("TestNoColor: Expected the monochrome success symbol, got", repr.String(term.String()))
+	}
+}
+
+// recordingTerminal is a virtual terminal that keeps every raw value written to it
+type recordingTerminal struct {
+	*virtualTerminal
+	raw strings.Builder
+}
+
+func (term *recordingTerminal) Write(p []byte) (int, error) {
+	term.raw.Write(p)
+	return term.virtualTerminal.Write(p)
+}
+
+func TestStatusSymbolCell(t *testing.T) {
+	defer applyTheme(NewThemeConfig())
+
+	tests := []struct {
+		symbols  StatusSymbolsConfig
+		symbol   string
+		expected string
+	}{
+		{StatusSymbolsConfig{}, "", "  "},
+		{StatusSymbolsConfig{Running: "*", Success: "ok"}, "*", "* "},
+		{StatusSymbolsConfig{Error: "fail"}, "", "    "},
+	}
+
+	for _, test := range tests {
+		newTheme := NewThemeConfig()
+		newTheme.StatusSymbols = test.symbols
+		applyTheme(newTheme)
+
+		actual := statusSymbolCell(test.symbol)
+		if test.expected != actual {
+			t.Error("TestStatusSymbolCell: Expected", repr.String(test.expected), "got", repr.String(actual))
+		}
+	}
+}
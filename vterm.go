@@ -0,0 +1,259 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rivo/uniseg"
+)
+
+// virtualTerminal is an in-memory renderer that interprets the ansi control codes written to it (cursor movement,
+// line erasure) into a grid of cells. This allows the final state of the screen to be inspected (e.g. by tests).
+type virtualTerminal struct {
+	lock sync.Mutex
+
+	// width is the number of columns in each row (lines longer than this wrap to the next row)
+	width int
+
+	// height is the number of rows reported as visible to the screen frame
+	height int
+
+	// rows is every row of cells that has been written to (the terminal grows downward without scrolling). Each cell holds
+	// a single grapheme cluster, where a wide cluster (e.g. CJK or emoji) is followed by an empty cell for every extra
+	// column it takes.
+	rows [][]string
+
+	// row and col is the current cursor position
+	row int
+	col int
+
+	// pending holds an incomplete escape sequence between writes
+	pending []rune
+
+	// savedRows, savedRow, and savedCol hold the primary screen while the alternate screen is shown
+	savedRows [][]string
+	savedRow  int
+	savedCol  int
+}
+
+// newVirtualTerminal creates an empty in-memory terminal with the given number of columns and visible rows
+func newVirtualTerminal(width, height int) *virtualTerminal {
+	return &virtualTerminal{width: width, height: height, rows: [][]string{{}}}
+}
+
+// Width returns the number of columns of the virtual terminal
+func (term *virtualTerminal) Width() int {
+	term.lock.Lock()
+	defer term.lock.Unlock()
+	return term.width
+}
+
+// Height returns the number of visible rows of the virtual terminal
+func (term *virtualTerminal) Height() int {
+	term.lock.Lock()
+	defer term.lock.Unlock()
+	return term.height
+}
+
+// Resize changes the dimensions of the virtual terminal (existing cells are not reflowed)
+func (term *virtualTerminal) Resize(width, height int) {
+	term.lock.Lock()
+	defer term.lock.Unlock()
+	term.width, term.height = width, height
+}
+
+// Write interprets the given bytes as characters and ansi control codes
+func (term *virtualTerminal) Write(p []byte) (int, error) {
+	term.lock.Lock()
+	defer term.lock.Unlock()
+
+	runes := append(term.pending, []rune(string(p))...)
+	term.pending = nil
+
+	for idx := 0; idx < len(runes); idx++ {
+		switch runes[idx] {
+		case '\x1b':
+			consumed, complete := term.escape(runes[idx:])
+			if !complete {
+				term.pending = append([]rune{}, runes[idx:]...)
+				return len(p), nil
+			}
+			idx += consumed - 1
+		case '\n':
+			term.moveTo(term.row+1, 0)
+		case '\r':
+			term.col = 0
+		default:
+			// the text up to the next control character is written a grapheme cluster at a time
+			end := idx
+			for end < len(runes) && runes[end] != '\x1b' && runes[end] != '\n' && runes[end] != '\r' {
+				end++
+			}
+			text, state := string(runes[idx:end]), -1
+			for len(text) > 0 {
+				var cluster string
+				var width int
+				cluster, text, width, state = uniseg.FirstGraphemeClusterInString(text, state)
+				term.put(cluster, width)
+			}
+			idx = end - 1
+		}
+	}
+	return len(p), nil
+}
+
+// escape interprets a single escape sequence at the start of the given runes, returning the number of runes consumed
+// and whether the sequence was complete
+func (term *virtualTerminal) escape(seq []rune) (int, bool) {
+	if len(seq) < 2 {
+		return 0, false
+	}
+	if seq[1] != '[' {
+		// a two character escape sequence (not supported, ignored)
+		return 2, true
+	}
+
+	for idx := 2; idx < len(seq); idx++ {
+		if seq[idx] >= 0x40 && seq[idx] <= 0x7e {
+			term.csi(string(seq[2:idx]), seq[idx])
+			return idx + 1, true
+		}
+	}
+	return 0, false
+}
+
+// csi applies a single control sequence (given its parameters and final character)
+func (term *virtualTerminal) csi(params string, final rune) {
+	// private modes (such as showing/hiding the cursor) have no effect on the cells, except for the alternate screen
+	if strings.HasPrefix(params, "?") {
+		switch {
+		case params == "?1049" && final == 'h' && term.savedRows == nil:
+			term.savedRows, term.savedRow, term.savedCol = term.rows, term.row, term.col
+			term.rows = [][]string{{}}
+			term.row, term.col = 0, 0
+		case params == "?1049" && final == 'l' && term.savedRows != nil:
+			term.rows, term.row, term.col = term.savedRows, term.savedRow, term.savedCol
+			term.savedRows = nil
+		}
+		return
+	}
+
+	values := strings.Split(params, ";")
+	param := func(index, defaultValue int) int {
+		if index >= len(values) || values[index] == "" {
+			return defaultValue
+		}
+		value, err := strconv.Atoi(values[index])
+		if err != nil {
+			return defaultValue
+		}
+		return value
+	}
+
+	switch final {
+	case 'A':
+		term.moveTo(term.row-param(0, 1), term.col)
+	case 'B':
+		term.moveTo(term.row+param(0, 1), term.col)
+	case 'C':
+		term.moveTo(term.row, term.col+param(0, 1))
+	case 'D':
+		term.moveTo(term.row, term.col-param(0, 1))
+	case 'G':
+		term.moveTo(term.row, param(0, 1)-1)
+	case 'H':
+		term.moveTo(param(0, 1)-1, param(1, 1)-1)
+	case 'K':
+		line := term.rows[term.row]
+		switch param(0, 0) {
+		case 0:
+			if term.col < len(line) {
+				term.rows[term.row] = line[:term.col]
+			}
+		case 1:
+			for idx := 0; idx <= term.col && idx < len(line); idx++ {
+				line[idx] = " "
+			}
+		case 2:
+			term.rows[term.row] = []string{}
+		}
+	case 'J':
+		switch param(0, 0) {
+		case 0:
+			term.csi("0", 'K')
+			term.rows = term.rows[:term.row+1]
+		case 2:
+			term.rows = [][]string{{}}
+			term.row, term.col = 0, 0
+		}
+	}
+	// all other sequences (such as SGR color values) do not affect the cell contents
+}
+
+// moveTo places the cursor at the given position, growing the terminal downward as needed
+func (term *virtualTerminal) moveTo(row, col int) {
+	if row < 0 {
+		row = 0
+	}
+	if col < 0 {
+		col = 0
+	}
+	if col > term.width-1 {
+		col = term.width - 1
+	}
+	for len(term.rows) <= row {
+		term.rows = append(term.rows, []string{})
+	}
+	term.row, term.col = row, col
+}
+
+// put writes a single grapheme cluster at the current cursor position and advances the cursor by its display width (a
+// cluster without width, such as a lone combining mark, joins the previous cell)
+func (term *virtualTerminal) put(cluster string, width int) {
+	if width == 0 {
+		if line := term.rows[term.row]; term.col > 0 && term.col <= len(line) {
+			line[term.col-1] += cluster
+		}
+		return
+	}
+	// a cluster that does not fit on the rest of the row wraps whole
+	if term.col+width > term.width {
+		term.moveTo(term.row+1, 0)
+	}
+	line := term.rows[term.row]
+	for len(line) < term.col+width {
+		line = append(line, " ")
+	}
+
+	// overwriting part of a wide cluster blanks the rest of it
+	start := term.col
+	for start > 0 && line[start] == "" {
+		start--
+	}
+	for idx := start; idx < term.col; idx++ {
+		line[idx] = " "
+	}
+	for idx := term.col + width; idx < len(line) && line[idx] == ""; idx++ {
+		line[idx] = " "
+	}
+
+	line[term.col] = cluster
+	for idx := 1; idx < width; idx++ {
+		line[term.col+idx] = ""
+	}
+	term.rows[term.row] = line
+	term.col += width
+}
+
+// String returns the visible contents of the terminal (trailing whitespace and trailing empty rows removed)
+func (term *virtualTerminal) String() string {
+	term.lock.Lock()
+	defer term.lock.Unlock()
+
+	lines := make([]string, len(term.rows))
+	for idx, row := range term.rows {
+		lines[idx] = strings.TrimRight(strings.Join(row, ""), " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n"
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alecthomas/repr"
+)
+
+func TestVirtualTerminal(t *testing.T) {
+	var testData = []struct {
+		name           string
+		width          int
+		input          []string
+		expectedOutput string
+	}{
+		{"plain lines", 20, []string{"Hello\nWorld\n"}, "Hello\nWorld\n"},
+		{"colors are ignored", 20, []string{"\x1b[38;5;10mHello\x1b[0m, World!"}, "Hello, World!\n"},
+		{"cursor up and overwrite", 20, []string{"one\ntwo\nthree\n", "\x1b[2A\x1b[2K\x1b[0G2\n"}, "one\n2\nthree\n"},
+		{"cursor down grows the screen", 20, []string{"one\x1b[2B\x1b[0Gthree"}, "one\n\nthree\n"},
+		{"erase to end of line", 20, []string{"Hello, World!\x1b[6D\x1b[K"}, "Hello,\n"},
+		{"escape split across writes", 20, []string{"one\ntwo\x1b[", "1A\x1b[0GONE"}, "ONE\ntwo\n"},
+		{"cursor visibility is ignored", 20, []string{"\x1b[?25lHi\x1b[?25h"}, "Hi\n"},
+		{"long lines wrap", 10, []string{"0123456789abc"}, "0123456789\nabc\n"},
+		{"wide characters take two columns", 20, []string{"日本語 ok\x1b[0G\x1b[4CX"}, "日本X  ok\n"},
+		{"wide characters wrap whole", 5, []string{"ab日本"}, "ab日\n本\n"},
+		{"combining marks share a cell", 20, []string{"e\u0301!\x1b[0G\x1b[1CX"}, "e\u0301X\n"},
+	}
+
+	for _, testObj := range testData {
+		term := newVirtualTerminal(testObj.width, 24)
+		for _, input := range testObj.input {
+			fmt.Fprint(term, input)
+		}
+		if term.String() != testObj.expectedOutput {
+			t.Error("TestVirtualTerminal (", testObj.name, "): Expected", repr.String(testObj.expectedOutput), "got", repr.String(term.String()))
+		}
+	}
+}